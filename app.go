@@ -1,7 +1,10 @@
 package docker
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/drone-plugins/drone-plugin-lib/drone"
 	"github.com/joho/godotenv"
@@ -46,6 +49,36 @@ func Run() {
 			Usage:  "git commit ref",
 			EnvVar: "DRONE_COMMIT_REF",
 		},
+		cli.StringFlag{
+			Name:   "commit.branch",
+			Usage:  "git commit branch",
+			EnvVar: "DRONE_COMMIT_BRANCH",
+		},
+		cli.StringFlag{
+			Name:   "commit.pull-request",
+			Usage:  "pull request number",
+			EnvVar: "DRONE_PULL_REQUEST",
+		},
+		cli.StringFlag{
+			Name:   "commit.tag",
+			Usage:  "git tag",
+			EnvVar: "DRONE_TAG",
+		},
+		cli.StringFlag{
+			Name:   "build.number",
+			Usage:  "build number",
+			EnvVar: "DRONE_BUILD_NUMBER",
+		},
+		cli.StringFlag{
+			Name:   "build.event",
+			Usage:  "build event",
+			EnvVar: "DRONE_BUILD_EVENT",
+		},
+		cli.Int64Flag{
+			Name:   "build.created",
+			Usage:  "build created unix timestamp",
+			EnvVar: "DRONE_BUILD_CREATED",
+		},
 		cli.StringFlag{
 			Name:   "daemon.mirror",
 			Usage:  "docker daemon registry mirror",
@@ -138,6 +171,56 @@ func Run() {
 			Usage:  "default build tags",
 			EnvVar: "PLUGIN_DEFAULT_TAGS,PLUGIN_AUTO_TAG",
 		},
+		cli.StringFlag{
+			Name:   "tags.strategy",
+			Usage:  "automatic tag strategy (default, branch)",
+			Value:  TagStrategyDefault,
+			EnvVar: "PLUGIN_TAG_STRATEGY",
+		},
+		cli.StringFlag{
+			Name:   "tags.generator",
+			Usage:  "automatic tag generator (semver, calver, describe)",
+			Value:  TagGeneratorSemver,
+			EnvVar: "PLUGIN_AUTO_TAG_GENERATOR",
+		},
+		cli.StringFlag{
+			Name:   "calver.format",
+			Usage:  "calendar version format for the calver tag generator",
+			Value:  "YYYY.0M.0D",
+			EnvVar: "PLUGIN_CALVER_FORMAT",
+		},
+		cli.StringSliceFlag{
+			Name:   "semver.components",
+			Usage:  "semver components to tag (major, minor, patch)",
+			Value:  &cli.StringSlice{"major", "minor", "patch"},
+			EnvVar: "PLUGIN_SEMVER_COMPONENTS",
+		},
+		cli.BoolFlag{
+			Name:   "semver.zero-major",
+			Usage:  "tag the major version of 0.x releases",
+			EnvVar: "PLUGIN_SEMVER_ZERO_MAJOR",
+		},
+		cli.BoolFlag{
+			Name:   "semver.channels",
+			Usage:  "add moving channel tags for pre-releases",
+			EnvVar: "PLUGIN_SEMVER_CHANNELS",
+		},
+		cli.BoolFlag{
+			Name:   "semver.latest",
+			Usage:  "tag the highest stable version as latest",
+			EnvVar: "PLUGIN_SEMVER_LATEST",
+		},
+		cli.StringFlag{
+			Name:   "semver.metadata",
+			Usage:  "build metadata handling (keep, ignore, tag)",
+			Value:  SemverMetadataKeep,
+			EnvVar: "PLUGIN_SEMVER_METADATA",
+		},
+		cli.StringFlag{
+			Name:   "tags.prefix",
+			Usage:  "git tag prefix or glob of the component built by this step, e.g. billing/ or services/*/",
+			EnvVar: "PLUGIN_TAG_PREFIX,PLUGIN_AUTO_TAG_PREFIX",
+		},
 		cli.StringFlag{
 			Name:   "tags.suffix",
 			Usage:  "default build tags with suffix",
@@ -277,11 +360,31 @@ func Run() {
 			Usage:  "docker json dockerconfig content",
 			EnvVar: "PLUGIN_CONFIG,DOCKER_PLUGIN_CONFIG",
 		},
+		cli.StringSliceFlag{
+			Name:   "docker.credential-helpers",
+			Usage:  "docker credential helpers as registry=helper pairs",
+			EnvVar: "PLUGIN_CREDENTIAL_HELPERS",
+		},
+		cli.StringFlag{
+			Name:   "docker.creds-store",
+			Usage:  "docker credential store",
+			EnvVar: "PLUGIN_CREDS_STORE",
+		},
 		cli.BoolTFlag{
 			Name:   "docker.purge",
 			Usage:  "docker should cleanup images",
 			EnvVar: "PLUGIN_PURGE",
 		},
+		cli.StringSliceFlag{
+			Name:   "docker.purge-policy",
+			Usage:  "docker cleanup policies (tagged, cache, dangling, none)",
+			EnvVar: "PLUGIN_PURGE_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "docker.purge-keep-storage",
+			Usage:  "amount of build cache to keep when pruning the builder cache",
+			EnvVar: "PLUGIN_PURGE_KEEP_STORAGE",
+		},
 		cli.StringFlag{
 			Name:   "repo.branch",
 			Usage:  "repository default branch",
@@ -501,10 +604,76 @@ func Run() {
 			Usage:  "inherit auth from docker daemon",
 			EnvVar: "PLUGIN_BUILDKIT_INHERIT_AUTH",
 		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Usage:  "maximum duration of the whole step (e.g. 45m), the build is cancelled when exceeded",
+			EnvVar: "PLUGIN_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   "timeout-grace-period",
+			Usage:  "time given to a cancelled command to exit before it is killed",
+			Value:  defaultGracePeriod,
+			EnvVar: "PLUGIN_TIMEOUT_GRACE_PERIOD",
+		},
+		cli.StringFlag{
+			Name:   "exec-transcript",
+			Usage:  "path to write a JSON lines transcript of every command executed by the plugin",
+			EnvVar: "PLUGIN_EXEC_TRANSCRIPT",
+		},
+		cli.StringFlag{
+			Name:   "exec-replay",
+			Usage:  "path to a command transcript to replay instead of running commands",
+			EnvVar: "PLUGIN_EXEC_REPLAY",
+		},
+		cli.StringFlag{
+			Name:   "settings-file",
+			Usage:  "path to a YAML or JSON file with plugin settings",
+			EnvVar: "PLUGIN_SETTINGS_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "plan-only",
+			Usage:  "print the commands the plugin would run as JSON without executing them",
+			EnvVar: "PLUGIN_PLAN_ONLY",
+		},
+		cli.StringFlag{
+			Name:   "plan-file",
+			Usage:  "file the plan is written to in plan mode, stdout when empty",
+			EnvVar: "PLUGIN_PLAN_FILE",
+		},
+		cli.StringFlag{
+			Name:   "immutable-tags",
+			Usage:  "protect existing tags in the registry (fail, skip)",
+			EnvVar: "PLUGIN_IMMUTABLE_TAGS",
+		},
+		cli.StringSliceFlag{
+			Name:   "mutable-tags",
+			Usage:  "tag patterns that may always be overwritten",
+			EnvVar: "PLUGIN_MUTABLE_TAGS",
+		},
+		cli.StringFlag{
+			Name:   "pull-registries",
+			Usage:  "registries to log in to for pulling images, as a JSON or YAML list",
+			EnvVar: "PLUGIN_PULL_REGISTRIES",
+		},
+		cli.StringFlag{
+			Name:   "destinations",
+			Usage:  "additional repositories to push the image to, as a JSON or YAML list",
+			EnvVar: "PLUGIN_DESTINATIONS",
+		},
+		cli.BoolFlag{
+			Name:   "verify-push",
+			Usage:  "verify the pushed tags, digest and platforms in the registry",
+			EnvVar: "PLUGIN_VERIFY_PUSH",
+		},
+		cli.BoolFlag{
+			Name:   "strict",
+			Usage:  "fail on configuration warnings",
+			EnvVar: "PLUGIN_STRICT",
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
-		logrus.Fatal(err)
+		logrus.Fatal(redact(err.Error()))
 	}
 }
 
@@ -515,8 +684,10 @@ func run(c *cli.Context) error {
 	}
 
 	plugin := Plugin{
-		Dryrun:  c.Bool("dry-run"),
-		Cleanup: c.BoolT("docker.purge"),
+		Dryrun:             c.Bool("dry-run"),
+		Cleanup:            c.BoolT("docker.purge"),
+		CleanupPolicy:      c.StringSlice("docker.purge-policy"),
+		CleanupKeepStorage: c.String("docker.purge-keep-storage"),
 		Login: Login{
 			Registry:    c.String("docker.registry"),
 			Username:    c.String("docker.username"),
@@ -524,6 +695,7 @@ func run(c *cli.Context) error {
 			Email:       c.String("docker.email"),
 			Config:      c.String("docker.config"),
 			AccessToken: c.String("access-token"),
+			CredsStore:  c.String("docker.creds-store"),
 		},
 		CardPath:         c.String("drone-card-path"),
 		MetadataFile:     c.String("metadata-file"),
@@ -613,27 +785,228 @@ func run(c *cli.Context) error {
 		TarPath:             c.String("tar-path"),
 		BuildxOutputFormat:  c.String("buildx-output-format"),
 		BuildkitInheritAuth: c.Bool("buildkit-inherit-auth"),
+		Timeout:             c.Duration("timeout"),
+		GracePeriod:         c.Duration("timeout-grace-period"),
+		Strict:              c.Bool("strict"),
+		ImmutableTags:       c.String("immutable-tags"),
+		MutableTags:         c.StringSlice("mutable-tags"),
+		VerifyPush:          c.Bool("verify-push"),
+	}
+
+	credHelpers, err := ParseCredHelpers(c.StringSlice("docker.credential-helpers"))
+	if err != nil {
+		return err
+	}
+	plugin.Login.CredHelpers = credHelpers
+	if plugin.PullRegistries, err = ParsePullRegistries(c.String("pull-registries")); err != nil {
+		return err
 	}
 
+	// flags and environment variables take precedence over the settings file
+	if path := c.String("settings-file"); path != "" {
+		settings, err := LoadSettings(path)
+		if err != nil {
+			return err
+		}
+		settings.Apply(&plugin, c.IsSet)
+	}
+
+	if path := c.String("exec-replay"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("unable to open command transcript %s: %s", path, err)
+		}
+		replay, err := NewReplayExecutor(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		// nothing is executed on replay, so the daemon must not be started
+		plugin.Daemon.Disabled = true
+		plugin.Executor = replay
+	}
+
+	if path := c.String("exec-transcript"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("unable to create command transcript %s: %s", path, err)
+		}
+		defer f.Close()
+		plugin.Executor = NewRecordingExecutor(plugin.executor(), f)
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+
+	if path := c.String("plan-file"); path != "" && c.Bool("plan-only") {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("unable to create plan file %s: %s", path, err)
+		}
+		defer f.Close()
+		plugin.PlanOutput = f
+	}
+	// skipped steps still print an empty plan in plan mode
+	skip := func(reason string) error {
+		logrus.Printf("skipping docker build for %s, %s", c.String("commit.ref"), reason)
+		if c.Bool("plan-only") {
+			return plugin.WriteSkippedPlan(reason)
+		}
+		return nil
+	}
+
+	// in a monorepo only the tags of this component are built
+	ref, ok := ComponentRef(c.String("commit.ref"), c.String("tags.prefix"))
+	if !ok {
+		return skip(fmt.Sprintf("the tag does not match prefix %s", c.String("tags.prefix")))
+	}
+
+	switch c.String("tags.strategy") {
+	case TagStrategyDefault, TagStrategyBranch:
+	default:
+		return fmt.Errorf("unknown tag strategy %q (PLUGIN_TAG_STRATEGY), expected %s or %s", c.String("tags.strategy"), TagStrategyDefault, TagStrategyBranch)
+	}
+	var generate TagGenerator
 	if c.Bool("tags.auto") {
+		var err error
+		if generate, err = tagGenerator(ctx, c, plugin); err != nil {
+			return err
+		}
+	}
+
+	if c.Bool("tags.auto") && c.String("tags.strategy") == TagStrategyBranch {
+		tags, push, err := BranchTags(
+			ref,
+			c.String("repo.branch"),
+			c.String("commit.pull-request"),
+			c.String("tags.suffix"),
+			generate,
+		)
+		if err != nil {
+			logrus.Printf("cannot build docker image for %s, %s", ref, err)
+			return err
+		}
+		if !push {
+			// pull requests are built to verify them, never published
+			logrus.Printf("skipping docker push for pull request build %s", ref)
+			plugin.Dryrun = true
+		} else if !UseDefaultTag(ref, c.String("repo.branch")) {
+			// branch tags move with every build of the branch
+			plugin.addMutableTags(tags...)
+		}
+		plugin.Build.Tags = tags
+	} else if c.Bool("tags.auto") {
 		if UseDefaultTag( // return true if tag event or default branch
-			c.String("commit.ref"),
+			ref,
 			c.String("repo.branch"),
 		) {
-			tag, err := DefaultTagSuffix(
-				c.String("commit.ref"),
-				c.String("tags.suffix"),
-			)
+			tag, err := generate(ref, c.String("tags.suffix"))
 			if err != nil {
-				logrus.Printf("cannot build docker image for %s, invalid semantic version", c.String("commit.ref"))
+				logrus.Printf("cannot build docker image for %s, %s", ref, err)
 				return err
 			}
 			plugin.Build.Tags = tag
 		} else {
-			logrus.Printf("skipping automated docker build for %s", c.String("commit.ref"))
-			return nil
+			return skip("automated tags are only built for tags and the default branch")
+		}
+	}
+
+	// render tag templates such as {{.Branch | sanitize}}-{{.ShortSHA}}
+	var created time.Time
+	if ts := c.Int64("build.created"); ts > 0 {
+		created = time.Unix(ts, 0)
+	}
+	tagContext := NewTagContext(
+		c.String("commit.sha"),
+		c.String("commit.ref"),
+		c.String("commit.branch"),
+		c.String("commit.tag"),
+		c.String("build.number"),
+		c.String("build.event"),
+		created,
+	)
+	tags, err := RenderTags(plugin.Build.Tags, tagContext)
+	if err != nil {
+		return err
+	}
+	plugin.Build.Tags = tags
+
+	// destinations without tags are pushed with the build tags
+	destinations, err := ParseDestinations(c.String("destinations"))
+	if err != nil {
+		return err
+	}
+	for i, d := range destinations {
+		if len(d.Tags) == 0 {
+			destinations[i].Tags = plugin.Build.Tags
+			continue
+		}
+		if destinations[i].Tags, err = RenderTags(d.Tags, tagContext); err != nil {
+			return err
 		}
 	}
+	plugin.Destinations = destinations
+
+	if c.Bool("plan-only") {
+		return plugin.WritePlan(ctx)
+	}
+
+	return plugin.ExecContext(ctx)
+}
 
-	return plugin.Exec()
+// helper function that returns the generator for automatic tags.
+func tagGenerator(ctx context.Context, c *cli.Context, plugin Plugin) (TagGenerator, error) {
+	switch c.String("tags.generator") {
+	case TagGeneratorSemver:
+		policy := SemverPolicy{
+			Components: c.StringSlice("semver.components"),
+			ZeroMajor:  c.Bool("semver.zero-major"),
+			Channels:   c.Bool("semver.channels"),
+			Latest:     c.Bool("semver.latest"),
+			Metadata:   c.String("semver.metadata"),
+		}
+		switch policy.Metadata {
+		case SemverMetadataKeep, SemverMetadataIgnore, SemverMetadataTag:
+		default:
+			return nil, fmt.Errorf("unknown semver metadata handling %q (PLUGIN_SEMVER_METADATA), expected %s, %s or %s", policy.Metadata, SemverMetadataKeep, SemverMetadataIgnore, SemverMetadataTag)
+		}
+		if policy.Latest {
+			versions, err := plugin.gitTags(ctx, c.String("tags.prefix"))
+			if err != nil {
+				fmt.Printf("Warning: unable to list git tags, latest is not tagged: %s\n", err)
+				policy.Latest = false
+			}
+			policy.Versions = versions
+		}
+		return func(ref, suffix string) ([]string, error) {
+			return SemverTagSuffix(ref, suffix, policy)
+		}, nil
+
+	case TagGeneratorCalVer:
+		return func(ref, suffix string) ([]string, error) {
+			created, err := plugin.gitCommitTime(ctx)
+			if err != nil {
+				fmt.Printf("Warning: unable to read the commit timestamp, using the build timestamp: %s\n", err)
+				created = time.Now()
+				if ts := c.Int64("build.created"); ts > 0 {
+					created = time.Unix(ts, 0)
+				}
+			}
+			version, err := CalVer(c.String("calver.format"), created, c.String("build.number"))
+			if err != nil {
+				return nil, err
+			}
+			return VersionTags(ref, version, suffix)
+		}, nil
+
+	case TagGeneratorDescribe:
+		return func(ref, suffix string) ([]string, error) {
+			version, err := plugin.gitDescribe(ctx, c.String("tags.prefix"))
+			if err != nil {
+				return nil, err
+			}
+			return VersionTags(ref, version, suffix)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown tag generator %q (PLUGIN_AUTO_TAG_GENERATOR), expected %s, %s or %s", c.String("tags.generator"), TagGeneratorSemver, TagGeneratorCalVer, TagGeneratorDescribe)
 }
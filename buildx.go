@@ -59,6 +59,7 @@ func cmdSetupBuildx(builder Builder, driverOpts []string, inheritAuth bool) *exe
 					if len(content) > maxSafeSize {
 						fmt.Fprintf(os.Stderr, "Warning: AWS_WEB_IDENTITY_TOKEN_FILE content size (%d bytes) exceeds safe command line argument size limit (%d bytes)\n", len(content), maxSafeSize)
 					}
+					addSecret(string(content))
 					buildkitdFlags = append(buildkitdFlags, fmt.Sprintf("--aws-token-content=%s", string(content)))
 					buildkitdFlags = append(buildkitdFlags, fmt.Sprintf("--aws-token-path=%s", tokenFilePath))
 				}
@@ -135,6 +136,7 @@ func appendHarnessCADriverOpts(args []string) []string {
 		}
 		return args
 	}
+	addSecret(string(content))
 	args = append(args, "--driver-opt", fmt.Sprintf("env.HARNESS_CA_CERT=%s", string(content)))
 	return args
 }
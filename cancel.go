@@ -0,0 +1,61 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defaultGracePeriod is how long a cancelled command is given to exit after
+// it has been asked to terminate, before it is killed.
+const defaultGracePeriod = 10 * time.Second
+
+// errStepTimeout is the cancellation cause when PLUGIN_TIMEOUT elapses.
+var errStepTimeout = errors.New("step timeout exceeded")
+
+// signalContext returns a context that is cancelled when the plugin receives
+// SIGINT or SIGTERM. The received signal is recorded as the cancellation cause.
+func signalContext(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			fmt.Printf("Received %s, cancelling the build\n", sig)
+			cancel(fmt.Errorf("received %s", sig))
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel(nil)
+	}
+}
+
+// cleanupContext returns a context for cleanup work that must still run once
+// ctx has been cancelled, bounded by the grace period.
+func cleanupContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), grace)
+}
+
+// stopProcess asks the process to terminate and kills it if it has not
+// exited, signalled by done being closed, within the grace period.
+func stopProcess(proc *os.Process, done <-chan struct{}, grace time.Duration) {
+	if err := terminateProcess(proc); err != nil {
+		proc.Kill()
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(grace):
+		fmt.Printf("Process %d did not exit within %s, killing it\n", proc.Pid, grace)
+		proc.Kill()
+	}
+}
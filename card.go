@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -17,9 +18,9 @@ import (
 	"github.com/inhies/go-bytesize"
 )
 
-func (p Plugin) writeCard() error {
+func (p Plugin) writeCard(ctx context.Context) error {
 	cmd := exec.Command(dockerExe, "inspect", p.Build.Name)
-	data, err := cmd.CombinedOutput()
+	data, err := runCombinedOutput(ctx, p.executor(), cmd)
 	if err != nil {
 		return err
 	}
@@ -52,8 +53,15 @@ func (p Plugin) writeCard() error {
 	return nil
 }
 
+// WriteCard writes the card to the card path. It lets the registry plugins
+// add their own results to the card of the step.
+func WriteCard(path string, card interface{}) {
+	writeCard(path, card)
+}
+
 func writeCard(path string, card interface{}) {
 	data, _ := json.Marshal(card)
+	data = []byte(redact(string(data)))
 	switch {
 	case path == "/dev/stdout":
 		writeCardTo(os.Stdout, data)
@@ -75,7 +83,11 @@ func writeCardTo(out io.Writer, data []byte) {
 func mapRegistryToURL(registry, repo string) (url string) {
 	url = "https://"
 	var domain string
-	if strings.Contains(registry, "amazonaws.com") {
+	if registry == "public.ecr.aws" {
+		// public repositories are shown in the gallery by alias and name
+		domain = "gallery.ecr.aws/"
+		repo = strings.TrimPrefix(repo, registry+"/")
+	} else if strings.Contains(registry, "amazonaws.com") {
 		domain = "gallery.ecr.aws/"
 	} else if strings.Contains(registry, "gcr.io") {
 		domain = "console.cloud.google.com/gcr/images"
@@ -83,6 +95,7 @@ func mapRegistryToURL(registry, repo string) (url string) {
 		// default to docker hub
 		domain = "hub.docker.com/r/"
 	}
-	url = path.Join(url, domain, repo)
+	// joined separately, since path.Join would collapse the scheme separator
+	url += path.Join(domain, repo)
 	return url
 }
@@ -0,0 +1,18 @@
+package docker
+
+import "testing"
+
+func TestMapRegistryToURL(t *testing.T) {
+	tests := []struct {
+		registry, repo, want string
+	}{
+		{registry: "", repo: "octocat/app", want: "https://hub.docker.com/r/octocat/app"},
+		{registry: "public.ecr.aws", repo: "public.ecr.aws/octocat/app", want: "https://gallery.ecr.aws/octocat/app"},
+		{registry: "gcr.io", repo: "gcr.io/octocat/app", want: "https://console.cloud.google.com/gcr/images/gcr.io/octocat/app"},
+	}
+	for _, test := range tests {
+		if got := mapRegistryToURL(test.registry, test.repo); got != test.want {
+			t.Errorf("mapRegistryToURL(%q, %q) = %q, want %q", test.registry, test.repo, got, test.want)
+		}
+	}
+}
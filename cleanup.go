@@ -0,0 +1,166 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/inhies/go-bytesize"
+)
+
+// Cleanup policies selected with PLUGIN_PURGE_POLICY.
+const (
+	cleanupTagged   = "tagged"   // remove the images tagged by this step
+	cleanupCache    = "cache"    // prune the buildx builder cache
+	cleanupDangling = "dangling" // prune dangling images
+	cleanupNone     = "none"     // keep everything
+)
+
+// defaultCleanupPolicy is applied when purge is enabled without a policy.
+var defaultCleanupPolicy = []string{cleanupTagged, cleanupDangling}
+
+// reclaimedSpace matches the space summary printed by docker image prune
+// ("Total reclaimed space: 1.2GB") and docker buildx prune ("Total: 1.2GB").
+var reclaimedSpace = regexp.MustCompile(`(?m)^Total(?: reclaimed space)?:\s*([\d.]+)\s*([kKMGTP]?i?B)\s*$`)
+
+// helper function that returns the cleanup policies to apply.
+func (p Plugin) cleanupPolicies() ([]string, error) {
+	if len(p.CleanupPolicy) == 0 {
+		return defaultCleanupPolicy, nil
+	}
+	var policies []string
+	for _, policy := range p.CleanupPolicy {
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		switch policy {
+		case "":
+			continue
+		case cleanupTagged, cleanupCache, cleanupDangling:
+			if !contains(policies, policy) {
+				policies = append(policies, policy)
+			}
+		case cleanupNone:
+			if len(p.CleanupPolicy) > 1 {
+				return nil, fmt.Errorf("purge policy %q cannot be combined with other policies", cleanupNone)
+			}
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("unknown purge policy %q, expected one of %s", policy,
+				strings.Join([]string{cleanupTagged, cleanupCache, cleanupDangling, cleanupNone}, ", "))
+		}
+	}
+	return policies, nil
+}
+
+// cleanup removes the images and build cache selected by the purge policies.
+// Failures are reported but never fail the step.
+func (p Plugin) cleanup(ctx context.Context) {
+	policies, err := p.cleanupPolicies()
+	if err != nil {
+		fmt.Printf("Skipping cleanup: %s\n", err)
+		return
+	}
+	if len(policies) == 0 {
+		fmt.Println("Cleanup policy is none, keeping images and build cache")
+		return
+	}
+
+	var cmds []*exec.Cmd
+	for _, policy := range policies {
+		switch policy {
+		case cleanupTagged:
+			if p.Build.BakeFile != "" {
+				fmt.Println("Bake mode: skipping removal of tagged images.")
+				continue
+			}
+			// the tags of the destinations are removed as well
+			for _, target := range p.targets() {
+				for _, tag := range target.Tags {
+					cmds = append(cmds, commandRmi(fmt.Sprintf("%s:%s", target.Repo, tag))) // docker rmi
+				}
+			}
+		case cleanupCache:
+			cmds = append(cmds, commandBuildxPrune(p.Builder.Name, p.CleanupKeepStorage)) // docker buildx prune
+		case cleanupDangling:
+			cmds = append(cmds, commandImagePrune()) // docker image prune -f
+		}
+	}
+
+	var reclaimed float64
+	var removed int
+	for _, cmd := range cmds {
+		cmd.Stderr = os.Stderr
+		trace(cmd)
+		out, err := runOutput(ctx, p.executor(), cmd)
+		os.Stdout.Write(out)
+		switch {
+		case err != nil && isCommandRmi(cmd.Args):
+			fmt.Printf("Could not remove image %s. Ignoring...\n", cmd.Args[2])
+		case err != nil:
+			fmt.Printf("Could not run %s. Ignoring...\n", strings.Join(cmd.Args[:3], " "))
+		case isCommandRmi(cmd.Args):
+			removed++
+		default:
+			reclaimed += parseReclaimedSpace(string(out))
+		}
+	}
+
+	fmt.Printf("Cleanup removed %d image(s) and reclaimed %s of disk space\n", removed, bytesize.New(reclaimed))
+}
+
+// helper function that returns the number of bytes reported as reclaimed in
+// the output of a prune command, or zero if it cannot be parsed.
+func parseReclaimedSpace(out string) float64 {
+	var total float64
+	for _, m := range reclaimedSpace.FindAllStringSubmatch(out, -1) {
+		value, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			continue
+		}
+		total += value * sizeUnit(m[2])
+	}
+	return total
+}
+
+// helper function that returns the multiplier for a size suffix as printed
+// by docker, which uses decimal units unless the suffix is binary (e.g. MiB).
+func sizeUnit(suffix string) float64 {
+	base := 1000.0
+	if strings.Contains(suffix, "i") {
+		base = 1024
+	}
+	switch strings.ToUpper(suffix[:1]) {
+	case "K":
+		return base
+	case "M":
+		return base * base
+	case "G":
+		return base * base * base
+	case "T":
+		return base * base * base * base
+	case "P":
+		return base * base * base * base * base
+	}
+	return 1
+}
+
+// helper function to create the docker buildx prune command.
+func commandBuildxPrune(builder, keepStorage string) *exec.Cmd {
+	args := []string{"buildx", "prune", "-f"}
+	if builder != "" {
+		args = append(args, "--builder", builder)
+	}
+	if keepStorage != "" {
+		args = append(args, "--keep-storage", keepStorage)
+	}
+	return exec.Command(dockerExe, args...)
+}
+
+// helper function to create the docker image prune command, which only
+// removes dangling images.
+func commandImagePrune() *exec.Cmd {
+	return exec.Command(dockerExe, "image", "prune", "-f")
+}
@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCleanupPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "default",
+			want: []string{cleanupTagged, cleanupDangling},
+		},
+		{
+			name:   "cache and dangling",
+			policy: []string{"Cache", " dangling", "cache"},
+			want:   []string{cleanupCache, cleanupDangling},
+		},
+		{
+			name:   "none",
+			policy: []string{"none"},
+		},
+		{
+			name:    "none combined",
+			policy:  []string{"none", "tagged"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown",
+			policy:  []string{"system"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Plugin{CleanupPolicy: tt.policy}.cleanupPolicies()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cleanupPolicies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cleanupPolicies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReclaimedSpace(t *testing.T) {
+	tests := []struct {
+		out  string
+		want float64
+	}{
+		{out: "Deleted Images:\ndeleted: sha256:abc\n\nTotal reclaimed space: 1.5GB\n", want: 1.5e9},
+		{out: "ID\tRECLAIMABLE\tSIZE\nabc\ttrue\t12MB\nTotal:\t12MB\n", want: 12e6},
+		{out: "Total reclaimed space: 2KiB\n", want: 2048},
+		{out: "Total reclaimed space: 0B\n", want: 0},
+		{out: "nothing to prune\n", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseReclaimedSpace(tt.out); got != tt.want {
+			t.Errorf("parseReclaimedSpace(%q) = %v, want %v", tt.out, got, tt.want)
+		}
+	}
+}
+
+func TestCleanupCommandPlan(t *testing.T) {
+	transcript := strings.Join([]string{
+		`{"args":["docker","rmi","octocat/app:latest"],"stdout":"Untagged: octocat/app:latest\n","exit_code":0}`,
+		`{"args":["docker","rmi","octocat/app:1.0"],"stderr":"No such image\n","exit_code":1}`,
+		`{"args":["docker","rmi","quay.io/octocat/app:1.0"],"stdout":"Untagged: quay.io/octocat/app:1.0\n","exit_code":0}`,
+		`{"args":["docker","buildx","prune","-f","--builder","builder-1","--keep-storage","10gb"],"stdout":"Total:\t1GB\n","exit_code":0}`,
+		`{"args":["docker","image","prune","-f"],"stdout":"Total reclaimed space: 500MB\n","exit_code":0}`,
+	}, "\n")
+
+	e, err := NewReplayExecutor(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatalf("unable to read transcript: %s", err)
+	}
+
+	p := Plugin{
+		Build:              Build{Repo: "octocat/app", Tags: []string{"latest", "1.0"}},
+		Destinations:       []Destination{{Repo: "quay.io/octocat/app", Tags: []string{"1.0"}}},
+		Builder:            Builder{Name: "builder-1"},
+		CleanupPolicy:      []string{"tagged", "cache", "dangling"},
+		CleanupKeepStorage: "10gb",
+		Executor:           e,
+	}
+	p.cleanup(context.Background())
+	if remaining := e.Remaining(); len(remaining) != 0 {
+		t.Errorf("Commands not executed: %+v", remaining)
+	}
+}
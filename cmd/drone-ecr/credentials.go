@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// roleConfig describes how the plugin assumes IAM roles before talking to
+// ECR. Without a web identity token the roles are assumed with the
+// credentials of the default chain.
+type roleConfig struct {
+	WebIdentityToken     string   // Web identity (OIDC) token
+	WebIdentityTokenFile string   // File holding the web identity token, read on every refresh
+	WebIdentityRole      string   // Role assumed with the web identity token
+	SessionName          string   // Role session name, generated by the SDK when empty
+	AssumeRoles          []string // Roles assumed in order, each with the credentials of the previous one
+	ExternalID           string   // External ID passed when assuming the roles
+	STSEndpoint          string   // Custom STS endpoint
+}
+
+// webIdentityToken is a web identity token given as a value.
+type webIdentityToken string
+
+// GetIdentityToken implements stscreds.IdentityTokenRetriever.
+func (t webIdentityToken) GetIdentityToken() ([]byte, error) {
+	return []byte(t), nil
+}
+
+// tokenRetriever returns the source of the web identity token, or nil when
+// no token is configured.
+func (rc roleConfig) tokenRetriever() stscreds.IdentityTokenRetriever {
+	switch {
+	case rc.WebIdentityToken != "":
+		return webIdentityToken(strings.TrimSpace(rc.WebIdentityToken))
+	case rc.WebIdentityTokenFile != "":
+		return stscreds.IdentityTokenFile(rc.WebIdentityTokenFile)
+	}
+	return nil
+}
+
+// assumeRoles returns a copy of cfg whose credentials come from the role
+// chain: the web identity role first, when a token is given, followed by
+// every role in AssumeRoles.
+func assumeRoles(cfg aws.Config, rc roleConfig) (aws.Config, error) {
+	if retriever := rc.tokenRetriever(); retriever != nil {
+		if rc.WebIdentityRole == "" {
+			return cfg, fmt.Errorf("a role ARN is required to use a web identity token")
+		}
+		// AssumeRoleWithWebIdentity is not signed, so it does not need
+		// credentials from the default chain
+		provider := stscreds.NewWebIdentityRoleProvider(rc.stsClient(cfg), rc.WebIdentityRole, retriever, func(o *stscreds.WebIdentityRoleOptions) {
+			o.RoleSessionName = rc.SessionName
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	} else if rc.WebIdentityRole != "" {
+		return cfg, fmt.Errorf("role %s requires a web identity token", rc.WebIdentityRole)
+	}
+
+	for _, role := range rc.AssumeRoles {
+		provider := stscreds.NewAssumeRoleProvider(rc.stsClient(cfg), role, func(o *stscreds.AssumeRoleOptions) {
+			if rc.ExternalID != "" {
+				o.ExternalID = aws.String(rc.ExternalID)
+			}
+			o.RoleSessionName = rc.SessionName
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return cfg, nil
+}
+
+// helper function to create an STS client, honoring the custom endpoint. Like
+// the ECR endpoint, a custom endpoint is used as is.
+func (rc roleConfig) stsClient(cfg aws.Config) *sts.Client {
+	return sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if rc.STSEndpoint != "" {
+			o.BaseEndpoint = aws.String(rc.STSEndpoint)
+			o.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateUnset
+			o.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateUnset
+		}
+	})
+}
+
+// helper function to split a comma separated list, e.g. of roles.
+func splitList(s string) []string {
+	var roles []string
+	for _, role := range strings.Split(s, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
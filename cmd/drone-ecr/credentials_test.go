@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// stsCall records a request made to the STS stand-in.
+type stsCall struct {
+	Action     string
+	RoleArn    string
+	Token      string
+	Session    string
+	ExternalID string
+	SignedBy   string // access key id of the signing credentials
+}
+
+// newTestSTS returns an STS stand-in that hands out credentials named after
+// the number of the call.
+func newTestSTS(t *testing.T, calls *[]stsCall) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		call := stsCall{
+			Action:     r.Form.Get("Action"),
+			RoleArn:    r.Form.Get("RoleArn"),
+			Token:      r.Form.Get("WebIdentityToken"),
+			Session:    r.Form.Get("RoleSessionName"),
+			ExternalID: r.Form.Get("ExternalId"),
+		}
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			credential := strings.SplitN(strings.SplitN(auth, "Credential=", 2)[1], "/", 2)
+			call.SignedBy = credential[0]
+		}
+		*calls = append(*calls, call)
+
+		key := fmt.Sprintf("KEY%d", len(*calls))
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>%[2]s</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>session</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>%[3]s</Arn>
+      <AssumedRoleId>ROLE:%[4]s</AssumedRoleId>
+    </AssumedRoleUser>
+  </%[1]sResult>
+</%[1]sResponse>`, call.Action, key, call.RoleArn, call.Session)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAssumeRoles(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("file-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  roleConfig
+		wantKey string
+		want    []stsCall
+	}{
+		{
+			name: "web identity token",
+			config: roleConfig{
+				WebIdentityToken: "env-token\n",
+				WebIdentityRole:  "arn:aws:iam::111111111111:role/ci",
+				SessionName:      "build-42",
+			},
+			wantKey: "KEY1",
+			want: []stsCall{
+				{Action: "AssumeRoleWithWebIdentity", RoleArn: "arn:aws:iam::111111111111:role/ci", Token: "env-token", Session: "build-42"},
+			},
+		},
+		{
+			name: "chained roles across accounts",
+			config: roleConfig{
+				WebIdentityTokenFile: tokenFile,
+				WebIdentityRole:      "arn:aws:iam::111111111111:role/ci",
+				SessionName:          "build-42",
+				AssumeRoles:          []string{"arn:aws:iam::222222222222:role/hop", "arn:aws:iam::333333333333:role/push"},
+				ExternalID:           "drone",
+			},
+			wantKey: "KEY3",
+			want: []stsCall{
+				{Action: "AssumeRoleWithWebIdentity", RoleArn: "arn:aws:iam::111111111111:role/ci", Token: "file-token", Session: "build-42"},
+				{Action: "AssumeRole", RoleArn: "arn:aws:iam::222222222222:role/hop", Session: "build-42", ExternalID: "drone", SignedBy: "KEY1"},
+				{Action: "AssumeRole", RoleArn: "arn:aws:iam::333333333333:role/push", Session: "build-42", ExternalID: "drone", SignedBy: "KEY2"},
+			},
+		},
+		{
+			name: "assume role with static keys",
+			config: roleConfig{
+				AssumeRoles: []string{"arn:aws:iam::222222222222:role/push"},
+				SessionName: "build-42",
+			},
+			wantKey: "KEY1",
+			want: []stsCall{
+				{Action: "AssumeRole", RoleArn: "arn:aws:iam::222222222222:role/push", Session: "build-42", SignedBy: "STATIC"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls []stsCall
+			test.config.STSEndpoint = newTestSTS(t, &calls).URL
+			cfg := aws.Config{
+				Region: defaultRegion,
+				Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+					return aws.Credentials{AccessKeyID: "STATIC", SecretAccessKey: "secret"}, nil
+				}),
+			}
+
+			cfg, err := assumeRoles(cfg, test.config)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			creds, err := cfg.Credentials.Retrieve(context.Background())
+			if err != nil {
+				t.Fatalf("unable to retrieve credentials: %s", err)
+			}
+			if creds.AccessKeyID != test.wantKey {
+				t.Errorf("got access key %s, want %s", creds.AccessKeyID, test.wantKey)
+			}
+			if !reflect.DeepEqual(calls, test.want) {
+				t.Errorf("got calls %+v, want %+v", calls, test.want)
+			}
+		})
+	}
+}
+
+func TestAssumeRolesErrors(t *testing.T) {
+	if _, err := assumeRoles(aws.Config{}, roleConfig{WebIdentityToken: "token"}); err == nil {
+		t.Error("expected an error for a token without a role")
+	}
+	if _, err := assumeRoles(aws.Config{}, roleConfig{WebIdentityRole: "arn:aws:iam::111111111111:role/ci"}); err == nil {
+		t.Error("expected an error for a role without a token")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	got := splitList(" arn:aws:iam::1:role/a, ,arn:aws:iam::2:role/b")
+	want := []string{"arn:aws:iam::1:role/a", "arn:aws:iam::2:role/b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got roles %q, want %q", got, want)
+	}
+}
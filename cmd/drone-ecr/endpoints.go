@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// endpointOptions returns the config options enabling FIPS and dual-stack
+// endpoints. They apply to every AWS client created from the config.
+func endpointOptions(fips, dualStack bool) []func(*config.LoadOptions) error {
+	var opts []func(*config.LoadOptions) error
+	if fips {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if dualStack {
+		opts = append(opts, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	return opts
+}
+
+// helper function to create an ECR client using the custom endpoint. A
+// custom endpoint is used as is, since the SDK does not combine it with the
+// FIPS and dual-stack settings.
+func newECRClient(cfg aws.Config, endpoint string) *ecr.Client {
+	return ecr.NewFromConfig(cfg, func(o *ecr.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateUnset
+			o.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateUnset
+		}
+	})
+}
+
+// registryHost returns the registry hostname of an ECR proxy endpoint, which
+// may use http when ECR is served from a custom endpoint.
+func registryHost(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
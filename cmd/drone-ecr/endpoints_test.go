@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// testRepo is a repository of the ECR stand-in.
+type testRepo struct {
+	ScanOnPush bool
+	Mutability string
+	Encryption string
+	KMSKey     string
+	Tags       map[string]string
+	Scans      []string            // scan findings responses in order, or the type of an error
+	ImageScans map[string][]string // scan findings responses by image digest, used before Scans
+	Manifests  map[string]string   // image manifests by digest
+}
+
+// newTestECR returns an ECR stand-in, like LocalStack, recording the called
+// operations with their repository. repos holds the existing repositories
+// and is updated by the calls.
+func newTestECR(t *testing.T, calls *[]string, repos map[string]*testRepo) *httptest.Server {
+	t.Helper()
+	type tag struct{ Key, Value string }
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonEC2ContainerRegistry_V20150921.")
+		var input struct {
+			RepositoryName             string
+			RepositoryNames            []string
+			ResourceArn                string
+			ImageTagMutability         string
+			ImageScanningConfiguration struct{ ScanOnPush bool }
+			EncryptionConfiguration    struct{ EncryptionType, KmsKey string }
+			Tags                       []tag
+			ImageId                    struct{ ImageDigest string }
+			ImageIds                   []struct{ ImageDigest string }
+		}
+		json.NewDecoder(r.Body).Decode(&input)
+		name := input.RepositoryName
+		if len(input.RepositoryNames) > 0 {
+			name = input.RepositoryNames[0]
+		}
+		if input.ResourceArn != "" {
+			name = strings.SplitN(input.ResourceArn, "repository/", 2)[1]
+		}
+		*calls = append(*calls, strings.TrimSuffix(operation+" "+name, " "))
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		repo := repos[name]
+		switch operation {
+		case "GetAuthorizationToken":
+			token := base64.StdEncoding.EncodeToString([]byte("AWS:password"))
+			fmt.Fprintf(w, `{"authorizationData": [{"authorizationToken": %q, "proxyEndpoint": %q}]}`, token, srv.URL)
+			return
+		case "CreateRepository":
+			if repo != nil {
+				w.Header().Set("X-Amzn-ErrorType", "RepositoryAlreadyExistsException")
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"__type": "RepositoryAlreadyExistsException", "message": "exists"}`)
+				return
+			}
+			repo = &testRepo{
+				ScanOnPush: input.ImageScanningConfiguration.ScanOnPush,
+				Mutability: input.ImageTagMutability,
+				Encryption: input.EncryptionConfiguration.EncryptionType,
+				KMSKey:     input.EncryptionConfiguration.KmsKey,
+				Tags:       map[string]string{},
+			}
+			if repo.Mutability == "" {
+				repo.Mutability = "MUTABLE"
+			}
+			if repo.Encryption == "" {
+				repo.Encryption = "AES256"
+			}
+			repos[name] = repo
+		case "PutImageScanningConfiguration":
+			repo.ScanOnPush = input.ImageScanningConfiguration.ScanOnPush
+		case "PutImageTagMutability":
+			repo.Mutability = input.ImageTagMutability
+		case "BatchGetImage":
+			var images []interface{}
+			for _, id := range input.ImageIds {
+				if manifest, ok := repo.Manifests[id.ImageDigest]; ok {
+					images = append(images, map[string]interface{}{
+						"imageId":       map[string]string{"imageDigest": id.ImageDigest},
+						"imageManifest": manifest,
+					})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"images": images})
+			return
+		case "DescribeImageScanFindings":
+			var response string
+			if scans := repo.ImageScans[input.ImageId.ImageDigest]; len(scans) > 0 {
+				response = scans[0]
+				repo.ImageScans[input.ImageId.ImageDigest] = scans[1:]
+			} else {
+				response = repo.Scans[0]
+				repo.Scans = repo.Scans[1:]
+			}
+			if !strings.HasPrefix(response, "{") {
+				w.Header().Set("X-Amzn-ErrorType", response)
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"__type": %q, "message": "error"}`, response)
+				return
+			}
+			io.WriteString(w, response)
+			return
+		}
+		for _, tag := range input.Tags {
+			repo.Tags[tag.Key] = tag.Value
+		}
+
+		switch operation {
+		case "DescribeRepositories":
+			json.NewEncoder(w).Encode(map[string]interface{}{"repositories": []interface{}{map[string]interface{}{
+				"repositoryName":             name,
+				"repositoryArn":              "arn:aws:ecr:us-east-1:000000000000:repository/" + name,
+				"imageTagMutability":         repo.Mutability,
+				"imageScanningConfiguration": map[string]bool{"scanOnPush": repo.ScanOnPush},
+				"encryptionConfiguration":    map[string]string{"encryptionType": repo.Encryption, "kmsKey": repo.KMSKey},
+			}}})
+		case "ListTagsForResource":
+			var tags []tag
+			for key, value := range repo.Tags {
+				tags = append(tags, tag{key, value})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"tags": tags})
+		default:
+			io.WriteString(w, `{}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCustomEndpoint(t *testing.T) {
+	var calls []string
+	srv := newTestECR(t, &calls, map[string]*testRepo{})
+	cfg := aws.Config{
+		Region:      defaultRegion,
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	}
+	svc, err := getECRClient(cfg, roleConfig{}, srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx := context.Background()
+	username, password, registry, err := getAuthInfo(ctx, svc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := strings.TrimPrefix(srv.URL, "http://"); registry != want {
+		t.Errorf("got registry %s, want %s", registry, want)
+	}
+	if username != "AWS" || password != "password" {
+		t.Errorf("got credentials %s:%s", username, password)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := ensureRepoExists(ctx, svc, "team/app", repositorySettings{ScanOnPush: aws.Bool(true)}); err != nil {
+			t.Fatalf("unable to create repository: %s", err)
+		}
+	}
+	if err := uploadLifeCyclePolicy(ctx, svc, `{"rules": []}`, "team/app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadRepositoryPolicy(ctx, svc, `{"Statement": []}`, "team/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GetAuthorizationToken",
+		"CreateRepository team/app",
+		"CreateRepository team/app",
+		"DescribeRepositories team/app",
+		"PutLifecyclePolicy team/app",
+		"SetRepositoryPolicy team/app",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+}
+
+// hostRecorder is an HTTP client recording the host of every request.
+type hostRecorder struct{ hosts []string }
+
+func (h *hostRecorder) Do(r *http.Request) (*http.Response, error) {
+	h.hosts = append(h.hosts, r.URL.Host)
+	return nil, fmt.Errorf("no network in tests")
+}
+
+func TestEndpointOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		fips      bool
+		dualStack bool
+		endpoint  string
+		want      string
+	}{
+		{name: "default", want: "api.ecr.us-east-1.amazonaws.com"},
+		{name: "fips", fips: true, want: "api.ecr-fips.us-east-1.amazonaws.com"},
+		{name: "dual-stack", dualStack: true, want: "ecr.us-east-1.api.aws"},
+		{name: "custom endpoint", fips: true, dualStack: true, endpoint: "https://ecr.internal.example.com", want: "ecr.internal.example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := &hostRecorder{}
+			opts := append([]func(*config.LoadOptions) error{
+				config.WithRegion(defaultRegion),
+				config.WithRetryMaxAttempts(1),
+				config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("key", "secret", "")),
+			}, endpointOptions(test.fips, test.dualStack)...)
+			cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.HTTPClient = recorder
+
+			newECRClient(cfg, test.endpoint).GetAuthorizationToken(context.Background(), &ecr.GetAuthorizationTokenInput{})
+			if len(recorder.hosts) != 1 || recorder.hosts[0] != test.want {
+				t.Errorf("got hosts %q, want %s", recorder.hosts, test.want)
+			}
+		})
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"https://000000000000.dkr.ecr.us-east-1.amazonaws.com": "000000000000.dkr.ecr.us-east-1.amazonaws.com",
+		"http://localhost.localstack.cloud:4566":               "localhost.localstack.cloud:4566",
+		"000000000000.dkr.ecr.us-east-1.amazonaws.com/":        "000000000000.dkr.ecr.us-east-1.amazonaws.com",
+	}
+	for in, want := range tests {
+		if got := registryHost(in); got != want {
+			t.Errorf("registryHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -7,21 +7,24 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
-	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
 
 	docker "github.com/drone-plugins/drone-buildx"
 )
 
-const defaultRegion = "us-east-1"
+const (
+	defaultRegion      = "us-east-1"
+	defaultScanTimeout = 10 * time.Minute
+)
 
 func main() {
 	if env := os.Getenv("PLUGIN_ENV_FILE"); env != "" {
@@ -39,7 +42,29 @@ func main() {
 		repositoryPolicy = getenv("PLUGIN_REPOSITORY_POLICY")
 		assumeRole       = getenv("PLUGIN_ASSUME_ROLE")
 		externalId       = getenv("PLUGIN_EXTERNAL_ID")
-		scanOnPush       = parseBoolOrDefault(false, getenv("PLUGIN_SCAN_ON_PUSH"))
+		scanOnPush       = getenv("PLUGIN_SCAN_ON_PUSH")
+		tagMutability    = getenv("PLUGIN_TAG_MUTABILITY")
+		encryptionType   = getenv("PLUGIN_ENCRYPTION_TYPE")
+		kmsKey           = getenv("PLUGIN_KMS_KEY")
+		repositoryTags   = getenv("PLUGIN_REPOSITORY_TAGS")
+		identityToken    = getenv("PLUGIN_WEB_IDENTITY_TOKEN", "PLUGIN_OIDC_TOKEN_ID")
+		identityFile     = getenv("PLUGIN_WEB_IDENTITY_TOKEN_FILE", "AWS_WEB_IDENTITY_TOKEN_FILE")
+		roleArn          = getenv("PLUGIN_ROLE_ARN", "AWS_ROLE_ARN")
+		sessionName      = getenv("PLUGIN_ROLE_SESSION_NAME", "AWS_ROLE_SESSION_NAME")
+		stsEndpoint      = getenv("PLUGIN_STS_ENDPOINT")
+		ecrEndpoint      = getenv("PLUGIN_ECR_ENDPOINT")
+		fips             = parseBoolOrDefault(false, getenv("PLUGIN_USE_FIPS_ENDPOINT"))
+		dualStack        = parseBoolOrDefault(false, getenv("PLUGIN_USE_DUALSTACK_ENDPOINT"))
+		waitScan         = parseBoolOrDefault(false, getenv("PLUGIN_WAIT_FOR_SCAN"))
+		scanLimit        = getenv("PLUGIN_SCAN_THRESHOLD")
+		scanReportFile   = getenv("PLUGIN_SCAN_REPORT")
+		scanTimeout      = getenv("PLUGIN_SCAN_TIMEOUT")
+		artifactFile     = getenv("PLUGIN_ARTIFACT_FILE")
+		public           = parseBoolOrDefault(false, getenv("PLUGIN_PUBLIC"))
+		publicAlias      = getenv("PLUGIN_PUBLIC_ALIAS")
+		description      = getenv("PLUGIN_PUBLIC_DESCRIPTION")
+		architectures    = getenv("PLUGIN_PUBLIC_ARCHITECTURES")
+		usageText        = getenv("PLUGIN_PUBLIC_USAGE_TEXT")
 	)
 
 	if region == "" {
@@ -55,12 +80,81 @@ func main() {
 
 	ctx := context.Background()
 
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	opts := append([]func(*config.LoadOptions) error{config.WithRegion(region)}, endpointOptions(fips, dualStack)...)
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
 		log.Fatal(fmt.Sprintf("error creating aws config: %v", err))
 	}
 
-	svc := getECRClient(cfg, assumeRole, externalId)
+	roles := roleConfig{
+		WebIdentityToken:     identityToken,
+		WebIdentityTokenFile: identityFile,
+		WebIdentityRole:      roleArn,
+		SessionName:          sessionName,
+		AssumeRoles:          splitList(assumeRole),
+		ExternalID:           externalId,
+		STSEndpoint:          stsEndpoint,
+	}
+
+	if public || registry == publicRegistry {
+		if lifecyclePolicy != "" || parseBoolOrDefault(false, scanOnPush) || waitScan || scanLimit != "" || scanReportFile != "" || tagMutability != "" || encryptionType != "" || kmsKey != "" {
+			log.Printf("warning: lifecycle policies, scanning, tag mutability and encryption are not supported by ECR Public and are ignored")
+		}
+		cfg, err := assumeRoles(cfg, roles)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("error assuming role: %v", err))
+		}
+		svc := newECRPublicClient(cfg, ecrEndpoint)
+		username, password, err := getPublicAuthInfo(ctx, svc)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("error getting ECR Public auth: %v", err))
+		}
+		name, alias, err := publicRepo(ctx, svc, repo, publicAlias)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("error getting ECR Public alias: %v", err))
+		}
+
+		if create {
+			catalog := publicCatalog{Description: description, Architectures: splitList(architectures)}
+			if usageText != "" {
+				p, err := os.ReadFile(usageText)
+				if err != nil {
+					log.Fatal(err)
+				}
+				catalog.UsageText = string(p)
+			}
+			settings, err := parseRepositorySettings("", "", "", "", repositoryTags)
+			if err != nil {
+				log.Fatal(fmt.Sprintf("error parsing ECR repo settings: %v", err))
+			}
+			if err := ensurePublicRepoExists(ctx, svc, name, catalog, settings.Tags); err != nil {
+				log.Fatal(fmt.Sprintf("error creating ECR Public repo: %v", err))
+			}
+		}
+
+		if repositoryPolicy != "" {
+			p, err := os.ReadFile(repositoryPolicy)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := uploadPublicRepositoryPolicy(ctx, svc, string(p), name); err != nil {
+				log.Fatal(fmt.Sprintf("error uploading ECR Public repository policy. %v", err))
+			}
+		}
+
+		os.Setenv("PLUGIN_REPO", fmt.Sprintf("%s/%s/%s", publicRegistry, alias, name))
+		os.Setenv("PLUGIN_REGISTRY", publicRegistry)
+		os.Setenv("DOCKER_USERNAME", username)
+		os.Setenv("DOCKER_PASSWORD", password)
+
+		docker.Run()
+		return
+	}
+
+	svc, err := getECRClient(cfg, roles, ecrEndpoint)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("error assuming role: %v", err))
+	}
 	username, password, defaultRegistry, err := getAuthInfo(ctx, svc)
 
 	if registry == "" {
@@ -76,13 +170,13 @@ func main() {
 	}
 
 	if create {
-		err = ensureRepoExists(ctx, svc, trimHostname(repo, registry), scanOnPush)
+		settings, err := parseRepositorySettings(scanOnPush, tagMutability, encryptionType, kmsKey, repositoryTags)
 		if err != nil {
-			log.Fatal(fmt.Sprintf("error creating ECR repo: %v", err))
+			log.Fatal(fmt.Sprintf("error parsing ECR repo settings: %v", err))
 		}
-		err = updateImageScanningConfig(ctx, svc, trimHostname(repo, registry), scanOnPush)
+		err = ensureRepoExists(ctx, svc, trimHostname(repo, registry), settings)
 		if err != nil {
-			log.Fatal(fmt.Sprintf("error updating scan on push for ECR repo: %v", err))
+			log.Fatal(fmt.Sprintf("error creating ECR repo: %v", err))
 		}
 	}
 
@@ -106,12 +200,55 @@ func main() {
 		}
 	}
 
+	threshold, err := parseScanThreshold(scanLimit)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("error parsing ECR scan threshold: %v", err))
+	}
+	waitScan = waitScan || len(threshold) > 0 || scanReportFile != ""
+	timeout := defaultScanTimeout
+	if scanTimeout != "" {
+		if timeout, err = time.ParseDuration(scanTimeout); err != nil {
+			log.Fatal(fmt.Sprintf("error parsing ECR scan timeout: %v", err))
+		}
+	}
+	cleanup := func() {}
+	if waitScan && artifactFile == "" {
+		// the digest of the pushed image is read from the artifact file
+		dir, err := os.MkdirTemp("", "drone-ecr")
+		if err != nil {
+			log.Fatal(err)
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+		defer cleanup()
+		// a failed build exits through logrus, which skips deferred calls
+		logrus.RegisterExitHandler(cleanup)
+		artifactFile = filepath.Join(dir, "artifact.json")
+		os.Setenv("PLUGIN_ARTIFACT_FILE", artifactFile)
+	}
+
 	os.Setenv("PLUGIN_REPO", repo)
 	os.Setenv("PLUGIN_REGISTRY", registry)
 	os.Setenv("DOCKER_USERNAME", username)
 	os.Setenv("DOCKER_PASSWORD", password)
 
 	docker.Run()
+
+	if waitScan && !parseBoolOrDefault(false, getenv("PLUGIN_DRY_RUN")) {
+		digest, err := artifactDigest(artifactFile, repo)
+		switch {
+		case errors.Is(err, errNothingPushed):
+			// pull requests, skipped tags and plans push nothing to scan
+			log.Printf("skipping ECR scan findings: %v", err)
+			err = nil
+		case err == nil:
+			err = checkScan(ctx, svc, trimHostname(repo, registry), digest, timeout, threshold, scanReportFile, getenv("DRONE_CARD_PATH"))
+		}
+		if err != nil {
+			// log.Fatal skips the deferred removal of the artifact file
+			cleanup()
+			log.Fatal(fmt.Sprintf("error checking ECR scan findings: %v", err))
+		}
+	}
 }
 
 func trimHostname(repo, registry string) string {
@@ -120,33 +257,6 @@ func trimHostname(repo, registry string) string {
 	return repo
 }
 
-func ensureRepoExists(ctx context.Context, svc *ecr.Client, name string, scanOnPush bool) error {
-	_, err := svc.CreateRepository(ctx, &ecr.CreateRepositoryInput{
-		RepositoryName: aws.String(name),
-		ImageScanningConfiguration: &ecrtypes.ImageScanningConfiguration{
-			ScanOnPush: scanOnPush,
-		},
-	})
-	if err != nil {
-		var rae *ecrtypes.RepositoryAlreadyExistsException
-		if errors.As(err, &rae) {
-			return nil
-		}
-		return err
-	}
-	return nil
-}
-
-func updateImageScanningConfig(ctx context.Context, svc *ecr.Client, name string, scanOnPush bool) error {
-	_, err := svc.PutImageScanningConfiguration(ctx, &ecr.PutImageScanningConfigurationInput{
-		RepositoryName: aws.String(name),
-		ImageScanningConfiguration: &ecrtypes.ImageScanningConfiguration{
-			ScanOnPush: scanOnPush,
-		},
-	})
-	return err
-}
-
 func uploadLifeCyclePolicy(ctx context.Context, svc *ecr.Client, lifecyclePolicy string, name string) error {
 	_, err := svc.PutLifecyclePolicy(ctx, &ecr.PutLifecyclePolicyInput{
 		LifecyclePolicyText: aws.String(lifecyclePolicy),
@@ -184,7 +294,7 @@ func getAuthInfo(ctx context.Context, svc *ecr.Client) (username, password, regi
 		return
 	}
 
-	registry = strings.TrimPrefix(*auth.ProxyEndpoint, "https://")
+	registry = registryHost(aws.ToString(auth.ProxyEndpoint))
 	creds := strings.SplitN(string(decoded), ":", 2)
 	if len(creds) < 2 {
 		err = fmt.Errorf("invalid ECR authorization token format")
@@ -214,19 +324,10 @@ func getenv(key ...string) (s string) {
 	return
 }
 
-func getECRClient(cfg aws.Config, role string, externalId string) *ecr.Client {
-	if role == "" {
-		return ecr.NewFromConfig(cfg)
-	}
-	stsSvc := sts.NewFromConfig(cfg)
-	var provider *stscreds.AssumeRoleProvider
-	if externalId != "" {
-		provider = stscreds.NewAssumeRoleProvider(stsSvc, role, func(o *stscreds.AssumeRoleOptions) {
-			o.ExternalID = &externalId
-		})
-	} else {
-		provider = stscreds.NewAssumeRoleProvider(stsSvc, role)
-	}
-	cfg.Credentials = aws.NewCredentialsCache(provider)
-	return ecr.NewFromConfig(cfg)
+func getECRClient(cfg aws.Config, roles roleConfig, endpoint string) (*ecr.Client, error) {
+	cfg, err := assumeRoles(cfg, roles)
+	if err != nil {
+		return nil, err
+	}
+	return newECRClient(cfg, endpoint), nil
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	ecrpublictypes "github.com/aws/aws-sdk-go-v2/service/ecrpublic/types"
+)
+
+const (
+	// publicRegistry is the registry of ECR Public.
+	publicRegistry = "public.ecr.aws"
+	// publicRegion is the only region serving the ECR Public API.
+	publicRegion = "us-east-1"
+)
+
+// publicCatalog is the catalog data of a public repository shown in the ECR
+// Public Gallery. Empty fields are left unchanged.
+type publicCatalog struct {
+	Description   string   // Short description of the images
+	Architectures []string // Architectures of the images, e.g. ARM 64 or x86-64
+	UsageText     string   // Usage instructions in markdown
+}
+
+// helper function to create an ECR Public client. The API is only served in
+// us-east-1, whatever region the registry is used from.
+func newECRPublicClient(cfg aws.Config, endpoint string) *ecrpublic.Client {
+	cfg.Region = publicRegion
+	return ecrpublic.NewFromConfig(cfg, func(o *ecrpublic.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateUnset
+			o.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateUnset
+		}
+	})
+}
+
+func getPublicAuthInfo(ctx context.Context, svc *ecrpublic.Client) (username, password string, err error) {
+	result, err := svc.GetAuthorizationToken(ctx, &ecrpublic.GetAuthorizationTokenInput{})
+	if err != nil {
+		return "", "", err
+	}
+	if result.AuthorizationData == nil {
+		return "", "", fmt.Errorf("no authorization data returned from ECR Public")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(aws.ToString(result.AuthorizationData.AuthorizationToken))
+	if err != nil {
+		return "", "", err
+	}
+	creds := strings.SplitN(string(decoded), ":", 2)
+	if len(creds) < 2 {
+		return "", "", fmt.Errorf("invalid ECR Public authorization token format")
+	}
+	return creds[0], creds[1], nil
+}
+
+// publicRepo returns the repository name and the alias of repo, which may be
+// given as the name, alias/name or with the public registry. An alias that is
+// neither given nor part of repo is looked up from the registry, preferring
+// the primary alias.
+func publicRepo(ctx context.Context, svc *ecrpublic.Client, repo, alias string) (name, repoAlias string, err error) {
+	name = trimHostname(repo, publicRegistry)
+	if alias != "" {
+		return strings.TrimPrefix(name, alias+"/"), alias, nil
+	}
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 && strings.HasPrefix(repo, publicRegistry+"/") {
+		return parts[1], parts[0], nil
+	}
+
+	out, err := svc.DescribeRegistries(ctx, &ecrpublic.DescribeRegistriesInput{})
+	if err != nil {
+		return "", "", err
+	}
+	for _, registry := range out.Registries {
+		for _, a := range registry.Aliases {
+			if a.PrimaryRegistryAlias || (alias == "" && a.DefaultRegistryAlias) {
+				alias = aws.ToString(a.Name)
+			}
+		}
+	}
+	if alias == "" {
+		return "", "", fmt.Errorf("no ECR Public registry alias found")
+	}
+	return strings.TrimPrefix(name, alias+"/"), alias, nil
+}
+
+// ensurePublicRepoExists creates the public repository with the catalog data
+// and tags. The catalog data of an existing repository is updated when it
+// differs from the declared one.
+func ensurePublicRepoExists(ctx context.Context, svc *ecrpublic.Client, name string, catalog publicCatalog, tags map[string]string) error {
+	input := &ecrpublic.CreateRepositoryInput{
+		RepositoryName: aws.String(name),
+		CatalogData:    catalog.input(ecrpublictypes.RepositoryCatalogData{}),
+	}
+	for _, tag := range (repositorySettings{Tags: tags}).resourceTags() {
+		input.Tags = append(input.Tags, ecrpublictypes.Tag{Key: tag.Key, Value: tag.Value})
+	}
+
+	_, err := svc.CreateRepository(ctx, input)
+	if err == nil {
+		log.Printf("created ECR Public repository %s", name)
+		return nil
+	}
+	var rae *ecrpublictypes.RepositoryAlreadyExistsException
+	if !errors.As(err, &rae) {
+		return err
+	}
+
+	out, err := svc.GetRepositoryCatalogData(ctx, &ecrpublic.GetRepositoryCatalogDataInput{
+		RepositoryName: aws.String(name),
+	})
+	if err != nil {
+		return err
+	}
+	var current ecrpublictypes.RepositoryCatalogData
+	if out.CatalogData != nil {
+		current = *out.CatalogData
+	}
+	updated := catalog.input(current)
+	if aws.ToString(updated.Description) == aws.ToString(current.Description) &&
+		aws.ToString(updated.UsageText) == aws.ToString(current.UsageText) &&
+		reflect.DeepEqual(updated.Architectures, current.Architectures) {
+		return nil
+	}
+	_, err = svc.PutRepositoryCatalogData(ctx, &ecrpublic.PutRepositoryCatalogDataInput{
+		RepositoryName: aws.String(name),
+		CatalogData:    updated,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("updated catalog data of ECR Public repository %s", name)
+	return nil
+}
+
+// input returns the catalog data to send, keeping the current values of the
+// fields that are not declared.
+func (c publicCatalog) input(current ecrpublictypes.RepositoryCatalogData) *ecrpublictypes.RepositoryCatalogDataInput {
+	input := &ecrpublictypes.RepositoryCatalogDataInput{
+		AboutText:        current.AboutText,
+		Architectures:    current.Architectures,
+		Description:      current.Description,
+		OperatingSystems: current.OperatingSystems,
+		UsageText:        current.UsageText,
+	}
+	if c.Description != "" {
+		input.Description = aws.String(c.Description)
+	}
+	if len(c.Architectures) > 0 {
+		input.Architectures = c.Architectures
+	}
+	if c.UsageText != "" {
+		input.UsageText = aws.String(c.UsageText)
+	}
+	return input
+}
+
+func uploadPublicRepositoryPolicy(ctx context.Context, svc *ecrpublic.Client, repositoryPolicy string, name string) error {
+	_, err := svc.SetRepositoryPolicy(ctx, &ecrpublic.SetRepositoryPolicyInput{
+		PolicyText:     aws.String(repositoryPolicy),
+		RepositoryName: aws.String(name),
+	})
+	return err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+)
+
+// testCatalog is the catalog data of a repository of the ECR Public stand-in.
+type testCatalog struct {
+	Description   string   `json:"description,omitempty"`
+	Architectures []string `json:"architectures,omitempty"`
+	UsageText     string   `json:"usageText,omitempty"`
+	AboutText     string   `json:"aboutText,omitempty"`
+}
+
+// newTestECRPublic returns an ECR Public stand-in with the octocat alias,
+// recording the called operations with their repository and region.
+func newTestECRPublic(t *testing.T, calls *[]string, repos map[string]*testCatalog) *ecrpublic.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "SpencerFrontendService.")
+		var input struct {
+			RepositoryName string
+			CatalogData    testCatalog
+		}
+		json.NewDecoder(r.Body).Decode(&input)
+		// the region is part of the credential scope of the signature
+		region := strings.Split(strings.SplitN(r.Header.Get("Authorization"), "Credential=", 2)[1], "/")[2]
+		*calls = append(*calls, strings.TrimSuffix(fmt.Sprintf("%s %s %s", region, operation, input.RepositoryName), " "))
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch operation {
+		case "GetAuthorizationToken":
+			token := base64.StdEncoding.EncodeToString([]byte("AWS:public-password"))
+			fmt.Fprintf(w, `{"authorizationData": {"authorizationToken": %q}}`, token)
+		case "DescribeRegistries":
+			io.WriteString(w, `{"registries": [{"aliases": [
+				{"name": "default", "defaultRegistryAlias": true},
+				{"name": "octocat", "primaryRegistryAlias": true}
+			]}]}`)
+		case "CreateRepository":
+			if repos[input.RepositoryName] != nil {
+				w.Header().Set("X-Amzn-ErrorType", "RepositoryAlreadyExistsException")
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"__type": "RepositoryAlreadyExistsException", "message": "exists"}`)
+				return
+			}
+			repos[input.RepositoryName] = &input.CatalogData
+			io.WriteString(w, `{}`)
+		case "GetRepositoryCatalogData":
+			json.NewEncoder(w).Encode(map[string]interface{}{"catalogData": repos[input.RepositoryName]})
+		case "PutRepositoryCatalogData":
+			repos[input.RepositoryName] = &input.CatalogData
+			io.WriteString(w, `{}`)
+		default:
+			io.WriteString(w, `{}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := aws.Config{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	}
+	return newECRPublicClient(cfg, srv.URL)
+}
+
+func TestPublicRepo(t *testing.T) {
+	var calls []string
+	svc := newTestECRPublic(t, &calls, map[string]*testCatalog{})
+	ctx := context.Background()
+
+	username, password, err := getPublicAuthInfo(ctx, svc)
+	if err != nil || username != "AWS" || password != "public-password" {
+		t.Errorf("got credentials %s:%s error %v", username, password, err)
+	}
+
+	tests := []struct {
+		repo, alias         string
+		wantName, wantAlias string
+	}{
+		{repo: "app", wantName: "app", wantAlias: "octocat"},
+		{repo: "app", alias: "team", wantName: "app", wantAlias: "team"},
+		{repo: "team/app", alias: "team", wantName: "app", wantAlias: "team"},
+		{repo: "public.ecr.aws/team/app", wantName: "app", wantAlias: "team"},
+	}
+	for _, test := range tests {
+		name, alias, err := publicRepo(ctx, svc, test.repo, test.alias)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.repo, err)
+			continue
+		}
+		if name != test.wantName || alias != test.wantAlias {
+			t.Errorf("%s: got %s with alias %s, want %s with alias %s", test.repo, name, alias, test.wantName, test.wantAlias)
+		}
+	}
+
+	// the API is always called in us-east-1
+	want := []string{"us-east-1 GetAuthorizationToken", "us-east-1 DescribeRegistries"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+}
+
+func TestEnsurePublicRepoExists(t *testing.T) {
+	var calls []string
+	repos := map[string]*testCatalog{
+		"existing": {Description: "old", AboutText: "about"},
+	}
+	svc := newTestECRPublic(t, &calls, repos)
+	ctx := context.Background()
+
+	catalog := publicCatalog{
+		Description:   "Drone plugin",
+		Architectures: []string{"ARM 64", "x86-64"},
+		UsageText:     "docker run public.ecr.aws/octocat/app",
+	}
+	for _, name := range []string{"app", "existing", "app"} {
+		if err := ensurePublicRepoExists(ctx, svc, name, catalog, map[string]string{"team": "platform"}); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+	}
+
+	want := map[string]*testCatalog{
+		"app": {Description: "Drone plugin", Architectures: []string{"ARM 64", "x86-64"}, UsageText: "docker run public.ecr.aws/octocat/app"},
+		// undeclared catalog data is kept
+		"existing": {Description: "Drone plugin", Architectures: []string{"ARM 64", "x86-64"}, UsageText: "docker run public.ecr.aws/octocat/app", AboutText: "about"},
+	}
+	if !reflect.DeepEqual(repos, want) {
+		t.Errorf("got repositories %+v, want %+v", repos, want)
+	}
+
+	wantCalls := []string{
+		"us-east-1 CreateRepository app",
+		"us-east-1 CreateRepository existing",
+		"us-east-1 GetRepositoryCatalogData existing",
+		"us-east-1 PutRepositoryCatalogData existing",
+		"us-east-1 CreateRepository app",
+		"us-east-1 GetRepositoryCatalogData app",
+	}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("got calls %q, want %q", calls, wantCalls)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// repositorySettings are the declared settings of the repository. New
+// repositories are created with them, existing ones are reconciled toward
+// them.
+type repositorySettings struct {
+	ScanOnPush     *bool             // Images are scanned on push, left unchanged when nil
+	TagMutability  string            // MUTABLE or IMMUTABLE, left unchanged when empty
+	EncryptionType string            // AES256, KMS or KMS_DSSE, the ECR default when empty
+	KMSKey         string            // KMS key used with KMS encryption, the AWS managed key when empty
+	Tags           map[string]string // AWS resource tags, tags that are not declared are kept
+}
+
+// parseRepositorySettings validates the repository settings. Tags are given
+// as comma separated key=value pairs.
+func parseRepositorySettings(scanOnPush, mutability, encryption, kmsKey, tags string) (repositorySettings, error) {
+	settings := repositorySettings{
+		TagMutability:  strings.ToUpper(strings.TrimSpace(mutability)),
+		EncryptionType: strings.ToUpper(strings.TrimSpace(encryption)),
+		KMSKey:         strings.TrimSpace(kmsKey),
+	}
+
+	if scanOnPush = strings.TrimSpace(scanOnPush); scanOnPush != "" {
+		enabled, err := strconv.ParseBool(scanOnPush)
+		if err != nil {
+			return settings, fmt.Errorf("invalid scan on push %s, must be true or false", scanOnPush)
+		}
+		settings.ScanOnPush = aws.Bool(enabled)
+	}
+
+	switch ecrtypes.ImageTagMutability(settings.TagMutability) {
+	case "", ecrtypes.ImageTagMutabilityMutable, ecrtypes.ImageTagMutabilityImmutable:
+	default:
+		return settings, fmt.Errorf("invalid tag mutability %s, must be MUTABLE or IMMUTABLE", mutability)
+	}
+
+	if settings.EncryptionType == "" && settings.KMSKey != "" {
+		settings.EncryptionType = string(ecrtypes.EncryptionTypeKms)
+	}
+	switch ecrtypes.EncryptionType(settings.EncryptionType) {
+	case "", ecrtypes.EncryptionTypeKms, ecrtypes.EncryptionTypeKmsDsse:
+	case ecrtypes.EncryptionTypeAes256:
+		if settings.KMSKey != "" {
+			return settings, fmt.Errorf("a KMS key cannot be used with AES256 encryption")
+		}
+	default:
+		return settings, fmt.Errorf("invalid encryption type %s, must be AES256, KMS or KMS_DSSE", encryption)
+	}
+
+	for _, pair := range strings.Split(tags, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return settings, fmt.Errorf("invalid repository tag %s, must be key=value", pair)
+		}
+		if settings.Tags == nil {
+			settings.Tags = map[string]string{}
+		}
+		settings.Tags[key] = strings.TrimSpace(value)
+	}
+	return settings, nil
+}
+
+// helper function that returns the declared tags sorted by key.
+func (s repositorySettings) resourceTags() []ecrtypes.Tag {
+	keys := make([]string, 0, len(s.Tags))
+	for key := range s.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var tags []ecrtypes.Tag
+	for _, key := range keys {
+		tags = append(tags, ecrtypes.Tag{Key: aws.String(key), Value: aws.String(s.Tags[key])})
+	}
+	return tags
+}
+
+// ensureRepoExists creates the repository with the declared settings. An
+// existing repository is reconciled toward them instead.
+func ensureRepoExists(ctx context.Context, svc *ecr.Client, name string, settings repositorySettings) error {
+	input := &ecr.CreateRepositoryInput{
+		RepositoryName:     aws.String(name),
+		ImageTagMutability: ecrtypes.ImageTagMutability(settings.TagMutability),
+		Tags:               settings.resourceTags(),
+	}
+	if settings.ScanOnPush != nil {
+		input.ImageScanningConfiguration = &ecrtypes.ImageScanningConfiguration{
+			ScanOnPush: *settings.ScanOnPush,
+		}
+	}
+	if settings.EncryptionType != "" {
+		input.EncryptionConfiguration = &ecrtypes.EncryptionConfiguration{
+			EncryptionType: ecrtypes.EncryptionType(settings.EncryptionType),
+		}
+		if settings.KMSKey != "" {
+			input.EncryptionConfiguration.KmsKey = aws.String(settings.KMSKey)
+		}
+	}
+
+	_, err := svc.CreateRepository(ctx, input)
+	if err != nil {
+		var rae *ecrtypes.RepositoryAlreadyExistsException
+		if errors.As(err, &rae) {
+			return reconcileRepo(ctx, svc, name, settings)
+		}
+		return err
+	}
+	log.Printf("created ECR repository %s", name)
+	return nil
+}
+
+// reconcileRepo updates the settings of an existing repository that differ
+// from the declared ones. The encryption cannot be changed after creation,
+// so a mismatch is only logged.
+func reconcileRepo(ctx context.Context, svc *ecr.Client, name string, settings repositorySettings) error {
+	out, err := svc.DescribeRepositories(ctx, &ecr.DescribeRepositoriesInput{
+		RepositoryNames: []string{name},
+	})
+	if err != nil {
+		return err
+	}
+	if len(out.Repositories) == 0 {
+		return fmt.Errorf("repository %s not found", name)
+	}
+	repo := out.Repositories[0]
+
+	if settings.ScanOnPush != nil && (repo.ImageScanningConfiguration == nil || repo.ImageScanningConfiguration.ScanOnPush != *settings.ScanOnPush) {
+		if err := updateImageScanningConfig(ctx, svc, name, *settings.ScanOnPush); err != nil {
+			return err
+		}
+		log.Printf("updated scan on push of ECR repository %s to %t", name, *settings.ScanOnPush)
+	}
+
+	if settings.TagMutability != "" && string(repo.ImageTagMutability) != settings.TagMutability {
+		_, err := svc.PutImageTagMutability(ctx, &ecr.PutImageTagMutabilityInput{
+			RepositoryName:     aws.String(name),
+			ImageTagMutability: ecrtypes.ImageTagMutability(settings.TagMutability),
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("updated tag mutability of ECR repository %s from %s to %s", name, repo.ImageTagMutability, settings.TagMutability)
+	}
+
+	if settings.EncryptionType != "" {
+		var current ecrtypes.EncryptionConfiguration
+		if repo.EncryptionConfiguration != nil {
+			current = *repo.EncryptionConfiguration
+		}
+		if string(current.EncryptionType) != settings.EncryptionType || (settings.KMSKey != "" && aws.ToString(current.KmsKey) != settings.KMSKey) {
+			log.Printf("warning: ECR repository %s is encrypted with %s %s, which cannot be changed to %s %s after creation",
+				name, current.EncryptionType, aws.ToString(current.KmsKey), settings.EncryptionType, settings.KMSKey)
+		}
+	}
+
+	if len(settings.Tags) > 0 {
+		return reconcileTags(ctx, svc, name, aws.ToString(repo.RepositoryArn), settings)
+	}
+	return nil
+}
+
+// reconcileTags adds the declared tags that are missing from the repository
+// or have a different value.
+func reconcileTags(ctx context.Context, svc *ecr.Client, name, arn string, settings repositorySettings) error {
+	out, err := svc.ListTagsForResource(ctx, &ecr.ListTagsForResourceInput{
+		ResourceArn: aws.String(arn),
+	})
+	if err != nil {
+		return err
+	}
+	current := map[string]string{}
+	for _, tag := range out.Tags {
+		current[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	var changed []ecrtypes.Tag
+	for _, tag := range settings.resourceTags() {
+		if value, ok := current[aws.ToString(tag.Key)]; !ok || value != aws.ToString(tag.Value) {
+			changed = append(changed, tag)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	_, err = svc.TagResource(ctx, &ecr.TagResourceInput{
+		ResourceArn: aws.String(arn),
+		Tags:        changed,
+	})
+	if err != nil {
+		return err
+	}
+	for _, tag := range changed {
+		log.Printf("tagged ECR repository %s with %s=%s", name, aws.ToString(tag.Key), aws.ToString(tag.Value))
+	}
+	return nil
+}
+
+func updateImageScanningConfig(ctx context.Context, svc *ecr.Client, name string, scanOnPush bool) error {
+	_, err := svc.PutImageScanningConfiguration(ctx, &ecr.PutImageScanningConfigurationInput{
+		RepositoryName: aws.String(name),
+		ImageScanningConfiguration: &ecrtypes.ImageScanningConfiguration{
+			ScanOnPush: scanOnPush,
+		},
+	})
+	return err
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+func TestParseRepositorySettings(t *testing.T) {
+	got, err := parseRepositorySettings("true", "immutable", "", "arn:aws:kms:us-east-1:000000000000:key/1", "team=platform, cost-center = 42")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := repositorySettings{
+		ScanOnPush:     aws.Bool(true),
+		TagMutability:  "IMMUTABLE",
+		EncryptionType: "KMS",
+		KMSKey:         "arn:aws:kms:us-east-1:000000000000:key/1",
+		Tags:           map[string]string{"team": "platform", "cost-center": "42"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, test := range [][5]string{
+		{"yes please", "", "", "", ""},
+		{"", "IMMUTABLE_WITH_EXCLUSION", "", "", ""},
+		{"", "", "AES128", "", ""},
+		{"", "", "AES256", "arn:aws:kms:us-east-1:000000000000:key/1", ""},
+		{"", "", "", "", "team"},
+	} {
+		if _, err := parseRepositorySettings(test[0], test[1], test[2], test[3], test[4]); err == nil {
+			t.Errorf("%q: expected an error", test)
+		}
+	}
+}
+
+func TestEnsureRepoExists(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	var calls []string
+	repos := map[string]*testRepo{
+		"team/existing": {
+			Mutability: "MUTABLE",
+			Encryption: "AES256",
+			Tags:       map[string]string{"team": "platform", "owner": "octocat"},
+		},
+	}
+	srv := newTestECR(t, &calls, repos)
+	cfg := aws.Config{
+		Region:      defaultRegion,
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	}
+	svc, _ := getECRClient(cfg, roleConfig{}, srv.URL)
+
+	settings := repositorySettings{
+		ScanOnPush:     aws.Bool(true),
+		TagMutability:  "IMMUTABLE",
+		EncryptionType: "KMS",
+		KMSKey:         "arn:aws:kms:us-east-1:000000000000:key/1",
+		Tags:           map[string]string{"team": "platform", "cost-center": "42"},
+	}
+	for _, name := range []string{"team/new", "team/existing"} {
+		if err := ensureRepoExists(context.Background(), svc, name, settings); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+	}
+
+	want := map[string]*testRepo{
+		"team/new": {
+			ScanOnPush: true,
+			Mutability: "IMMUTABLE",
+			Encryption: "KMS",
+			KMSKey:     "arn:aws:kms:us-east-1:000000000000:key/1",
+			Tags:       map[string]string{"team": "platform", "cost-center": "42"},
+		},
+		// the encryption cannot be changed and undeclared tags are kept
+		"team/existing": {
+			ScanOnPush: true,
+			Mutability: "IMMUTABLE",
+			Encryption: "AES256",
+			Tags:       map[string]string{"team": "platform", "owner": "octocat", "cost-center": "42"},
+		},
+	}
+	if !reflect.DeepEqual(repos, want) {
+		t.Errorf("got repositories %+v, want %+v", repos, want)
+	}
+
+	wantCalls := []string{
+		"CreateRepository team/new",
+		"CreateRepository team/existing",
+		"DescribeRepositories team/existing",
+		"PutImageScanningConfiguration team/existing",
+		"PutImageTagMutability team/existing",
+		"ListTagsForResource team/existing",
+		"TagResource team/existing",
+	}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("got calls %q, want %q", calls, wantCalls)
+	}
+
+	for _, line := range []string{
+		"created ECR repository team/new",
+		"updated scan on push of ECR repository team/existing to true",
+		"updated tag mutability of ECR repository team/existing from MUTABLE to IMMUTABLE",
+		"warning: ECR repository team/existing is encrypted with AES256",
+		"tagged ECR repository team/existing with cost-center=42",
+	} {
+		if !strings.Contains(output.String(), line) {
+			t.Errorf("log does not contain %q:\n%s", line, output.String())
+		}
+	}
+
+	// a reconciled repository is left alone
+	calls = nil
+	if err := ensureRepoExists(context.Background(), svc, "team/new", settings); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	wantCalls = []string{"CreateRepository team/new", "DescribeRepositories team/new", "ListTagsForResource team/new"}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("got calls %q, want %q", calls, wantCalls)
+	}
+
+	// scan on push is left unchanged when it is not declared
+	calls = nil
+	if err := ensureRepoExists(context.Background(), svc, "team/new", repositorySettings{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	wantCalls = []string{"CreateRepository team/new", "DescribeRepositories team/new"}
+	if !reflect.DeepEqual(calls, wantCalls) || !repos["team/new"].ScanOnPush {
+		t.Errorf("got calls %q and scan on push %t, want %q and true", calls, repos["team/new"].ScanOnPush, wantCalls)
+	}
+}
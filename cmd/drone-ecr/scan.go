@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+	droneclient "github.com/drone/drone-go/drone"
+
+	docker "github.com/drone-plugins/drone-buildx"
+)
+
+// scanPollInterval is the time between two scan findings requests.
+var scanPollInterval = 5 * time.Second
+
+// severities ranks the finding severities from low to high.
+var severities = map[string]int{
+	"UNDEFINED":     0,
+	"UNTRIAGED":     0,
+	"INFORMATIONAL": 1,
+	"LOW":           2,
+	"MEDIUM":        3,
+	"HIGH":          4,
+	"CRITICAL":      5,
+}
+
+// scanThreshold is the number of findings allowed per severity. Severities
+// that are not listed are not limited.
+type scanThreshold map[string]int
+
+// parseScanThreshold parses comma separated SEVERITY=max pairs. A severity
+// without a count allows no findings of that severity or higher.
+func parseScanThreshold(s string) (scanThreshold, error) {
+	threshold := scanThreshold{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		severity, count, hasCount := strings.Cut(pair, "=")
+		severity = strings.ToUpper(strings.TrimSpace(severity))
+		rank, ok := severities[severity]
+		if !ok {
+			return nil, fmt.Errorf("invalid scan severity %s", severity)
+		}
+		if !hasCount {
+			for name, r := range severities {
+				if r >= rank {
+					threshold[name] = 0
+				}
+			}
+			continue
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf("invalid number of %s findings %s", severity, count)
+		}
+		threshold[severity] = max
+	}
+	return threshold, nil
+}
+
+// exceeded returns the severities whose findings exceed the threshold.
+func (t scanThreshold) exceeded(counts map[string]int32) []string {
+	var problems []string
+	for _, severity := range sortedSeverities(counts) {
+		if max, ok := t[severity]; ok && int(counts[severity]) > max {
+			problems = append(problems, fmt.Sprintf("%d %s findings, %d allowed", counts[severity], severity, max))
+		}
+	}
+	return problems
+}
+
+// exceededReport returns the severities whose findings exceed the threshold
+// in a scan report. The threshold applies to each platform of a multi
+// platform image rather than to the sum of their findings.
+func (t scanThreshold) exceededReport(r scanReport) []string {
+	if len(r.Platforms) == 0 {
+		return t.exceeded(r.Counts)
+	}
+	var problems []string
+	for _, platform := range r.Platforms {
+		for _, problem := range t.exceeded(platform.Counts) {
+			problems = append(problems, fmt.Sprintf("%s: %s", platform.Platform, problem))
+		}
+	}
+	return problems
+}
+
+// indexMediaTypes are the media types of multi platform images.
+var indexMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+}
+
+// manifestMediaTypes are the media types accepted when looking up the pushed
+// manifest, so that an index is returned as is.
+var manifestMediaTypes = append([]string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}, indexMediaTypes...)
+
+// scanReport holds the scan findings of a pushed image. The report of a multi
+// platform image holds the report of every platform, with the highest count
+// of any platform per severity and the findings of all platforms.
+type scanReport struct {
+	Repository string           `json:"repository"`
+	Digest     string           `json:"digest"`
+	Platform   string           `json:"platform,omitempty"`
+	Status     string           `json:"status"`
+	Counts     map[string]int32 `json:"severity_counts"`
+	Findings   []scanFinding    `json:"findings"`
+	Platforms  []scanReport     `json:"platforms,omitempty"`
+}
+
+// platformManifest is a platform manifest of a pushed image.
+type platformManifest struct {
+	Platform string // os/arch[/variant], empty for a single platform image
+	Digest   string
+}
+
+// scanFinding is a single finding of the scan report.
+type scanFinding struct {
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+	URI      string `json:"uri,omitempty"`
+}
+
+// summary returns the finding counts from the highest severity down.
+func (r scanReport) summary() string {
+	if len(r.Counts) == 0 {
+		return "no findings"
+	}
+	var parts []string
+	for _, severity := range sortedSeverities(r.Counts) {
+		parts = append(parts, fmt.Sprintf("%s=%d", severity, r.Counts[severity]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// helper function that returns the severities of counts from the highest
+// severity down.
+func sortedSeverities(counts map[string]int32) []string {
+	var names []string
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if severities[names[i]] != severities[names[j]] {
+			return severities[names[i]] > severities[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// waitForScan polls the scan findings of the image until the scan is
+// complete or the timeout expires. Basic and enhanced scanning findings are
+// both collected.
+func waitForScan(ctx context.Context, svc *ecr.Client, name, digest string, timeout time.Duration) (scanReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	report := scanReport{Repository: name, Digest: digest, Counts: map[string]int32{}}
+	input := &ecr.DescribeImageScanFindingsInput{
+		RepositoryName: aws.String(name),
+		ImageId:        &ecrtypes.ImageIdentifier{ImageDigest: aws.String(digest)},
+	}
+	timedOut := fmt.Errorf("timed out after %s waiting for the scan of %s@%s", timeout, name, digest)
+	for {
+		out, err := svc.DescribeImageScanFindings(ctx, input)
+		var status ecrtypes.ScanStatus
+		var snf *ecrtypes.ScanNotFoundException
+		switch {
+		case errors.As(err, &snf):
+			// the scan of a freshly pushed image may not have started yet
+		case err != nil && ctx.Err() != nil:
+			return report, timedOut
+		case err != nil:
+			return report, err
+		case out.ImageScanStatus != nil:
+			status = out.ImageScanStatus.Status
+		}
+
+		switch status {
+		case ecrtypes.ScanStatusComplete, ecrtypes.ScanStatusActive:
+			report.Status = string(status)
+			report.addFindings(out.ImageScanFindings)
+			if out.NextToken == nil {
+				return report, nil
+			}
+			input.NextToken = out.NextToken
+			continue
+		case "", ecrtypes.ScanStatusInProgress, ecrtypes.ScanStatusPending:
+		default:
+			return report, fmt.Errorf("scan of %s@%s ended with status %s: %s", name, digest, status, aws.ToString(out.ImageScanStatus.Description))
+		}
+
+		select {
+		case <-ctx.Done():
+			return report, timedOut
+		case <-time.After(scanPollInterval):
+		}
+	}
+}
+
+// platformManifests returns the manifests ECR scans for the pushed image: the
+// platform manifests of an index, or the image itself. Attestation manifests
+// of an index are not scanned and are left out.
+func platformManifests(ctx context.Context, svc *ecr.Client, name, digest string) ([]platformManifest, error) {
+	out, err := svc.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+		RepositoryName:     aws.String(name),
+		ImageIds:           []ecrtypes.ImageIdentifier{{ImageDigest: aws.String(digest)}},
+		AcceptedMediaTypes: manifestMediaTypes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	image := []platformManifest{{Digest: digest}}
+	if len(out.Images) == 0 {
+		return image, nil
+	}
+	var index struct {
+		MediaType string `json:"mediaType"`
+		Manifests []struct {
+			Digest   string `json:"digest"`
+			Platform *struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+				Variant      string `json:"variant"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal([]byte(aws.ToString(out.Images[0].ImageManifest)), &index); err != nil {
+		return nil, fmt.Errorf("invalid manifest of %s@%s: %s", name, digest, err)
+	}
+	mediaType := aws.ToString(out.Images[0].ImageManifestMediaType)
+	if index.MediaType != "" {
+		mediaType = index.MediaType
+	}
+	if !contains(indexMediaTypes, mediaType) {
+		return image, nil
+	}
+
+	var manifests []platformManifest
+	for _, m := range index.Manifests {
+		if m.Platform == nil || m.Platform.OS == "unknown" {
+			continue
+		}
+		platform := m.Platform.OS + "/" + m.Platform.Architecture
+		if m.Platform.Variant != "" {
+			platform += "/" + m.Platform.Variant
+		}
+		manifests = append(manifests, platformManifest{Platform: platform, Digest: m.Digest})
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("no platform manifests found in %s@%s", name, digest)
+	}
+	return manifests, nil
+}
+
+// scanImage waits for the scan findings of the pushed image. The platforms
+// of a multi platform image are scanned separately and their findings are
+// aggregated, with the highest count of any platform for each severity. The
+// timeout applies to the whole image.
+func scanImage(ctx context.Context, svc *ecr.Client, name, digest string, timeout time.Duration) (scanReport, error) {
+	manifests, err := platformManifests(ctx, svc, name, digest)
+	if err != nil {
+		return scanReport{}, err
+	}
+	if len(manifests) == 1 && manifests[0].Platform == "" {
+		return waitForScan(ctx, svc, name, digest, timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	report := scanReport{Repository: name, Digest: digest, Counts: map[string]int32{}}
+	seen := map[scanFinding]bool{}
+	for _, m := range manifests {
+		platform, err := waitForScan(ctx, svc, name, m.Digest, timeout)
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", m.Platform, err)
+		}
+		platform.Platform = m.Platform
+		report.Platforms = append(report.Platforms, platform)
+		report.Status = platform.Status
+		for severity, count := range platform.Counts {
+			if count > report.Counts[severity] {
+				report.Counts[severity] = count
+			}
+		}
+		// most findings are shared by the platforms
+		for _, f := range platform.Findings {
+			if !seen[f] {
+				seen[f] = true
+				report.Findings = append(report.Findings, f)
+			}
+		}
+	}
+	return report, nil
+}
+
+// helper function that reports whether a list contains a value.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to add a page of findings to the report.
+func (r *scanReport) addFindings(findings *ecrtypes.ImageScanFindings) {
+	if findings == nil {
+		return
+	}
+	for severity, count := range findings.FindingSeverityCounts {
+		r.Counts[severity] = count
+	}
+	for _, f := range findings.Findings {
+		r.Findings = append(r.Findings, scanFinding{
+			Name:     aws.ToString(f.Name),
+			Severity: string(f.Severity),
+			URI:      aws.ToString(f.Uri),
+		})
+	}
+	for _, f := range findings.EnhancedFindings {
+		finding := scanFinding{Name: aws.ToString(f.Title), Severity: aws.ToString(f.Severity)}
+		if details := f.PackageVulnerabilityDetails; details != nil {
+			if id := aws.ToString(details.VulnerabilityId); id != "" {
+				finding.Name = id
+			}
+			finding.URI = aws.ToString(details.SourceUrl)
+		}
+		r.Findings = append(r.Findings, finding)
+	}
+}
+
+// writeReport writes the scan report as JSON.
+func (r scanReport) writeReport(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// writeScanCard adds the finding counts to the card written by the build.
+// A card streamed to the log cannot be updated, so a separate card holding
+// only the scan results is written instead.
+func (r scanReport) writeScanCard(path string) {
+	if path == "" {
+		return
+	}
+	card := droneclient.CardInput{Schema: "https://drone-plugins.github.io/drone-docker/card.json"}
+	data := map[string]interface{}{}
+	if path != "/dev/stdout" && path != "/dev/stderr" {
+		if existing, err := os.ReadFile(path); err == nil && json.Unmarshal(existing, &card) == nil {
+			json.Unmarshal(card.Data, &data)
+		}
+	}
+	findings := map[string]interface{}{
+		"Digest":  r.Digest,
+		"Status":  r.Status,
+		"Counts":  r.Counts,
+		"Summary": r.summary(),
+	}
+	if len(r.Platforms) > 0 {
+		platforms := map[string]string{}
+		for _, platform := range r.Platforms {
+			platforms[platform.Platform] = platform.summary()
+		}
+		findings["Platforms"] = platforms
+	}
+	data["ScanFindings"] = findings
+	card.Data, _ = json.Marshal(data)
+	docker.WriteCard(path, &card)
+}
+
+// errNothingPushed is returned by artifactDigest when the step did not push
+// the repository, e.g. for pull requests or skipped tags.
+var errNothingPushed = errors.New("nothing was pushed")
+
+// artifactDigest returns the digest of repo recorded in the artifact file.
+func artifactDigest(path, repo string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w, no artifact file %s", errNothingPushed, path)
+	}
+	if err != nil {
+		return "", err
+	}
+	var artifact drone.DockerArtifact
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		return "", err
+	}
+	for _, image := range artifact.Data.Images {
+		if strings.HasPrefix(image.Image, repo+":") && image.Digest != "" {
+			return image.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("%w, no pushed image of %s found", errNothingPushed, repo)
+}
+
+// checkScan waits for the scan findings of the pushed image, reports them
+// and fails when they exceed the threshold.
+func checkScan(ctx context.Context, svc *ecr.Client, name, digest string, timeout time.Duration, threshold scanThreshold, reportPath, cardPath string) error {
+	report, err := scanImage(ctx, svc, name, digest, timeout)
+	if err != nil {
+		return err
+	}
+	for _, platform := range report.Platforms {
+		log.Printf("scan findings for %s@%s (%s): %s", name, platform.Digest, platform.Platform, platform.summary())
+	}
+	log.Printf("scan findings for %s@%s: %s", name, digest, report.summary())
+	if reportPath != "" {
+		if err := report.writeReport(reportPath); err != nil {
+			return fmt.Errorf("unable to write scan report: %s", err)
+		}
+	}
+	report.writeScanCard(cardPath)
+	if problems := threshold.exceededReport(report); len(problems) > 0 {
+		return fmt.Errorf("scan findings of %s@%s exceed the threshold:\n  - %s", name, digest, strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	droneclient "github.com/drone/drone-go/drone"
+)
+
+func TestParseScanThreshold(t *testing.T) {
+	got, err := parseScanThreshold("high, medium=5")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := scanThreshold{"CRITICAL": 0, "HIGH": 0, "MEDIUM": 5}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	problems := got.exceeded(map[string]int32{"CRITICAL": 1, "MEDIUM": 5, "LOW": 12})
+	if want := []string{"1 CRITICAL findings, 0 allowed"}; !reflect.DeepEqual(problems, want) {
+		t.Errorf("got problems %q, want %q", problems, want)
+	}
+
+	for _, s := range []string{"SEVERE", "HIGH=-1", "HIGH=many"} {
+		if _, err := parseScanThreshold(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+const (
+	testScanPage1 = `{"imageScanStatus": {"status": "COMPLETE"}, "nextToken": "page2", "imageScanFindings": {
+		"findingSeverityCounts": {"HIGH": 1, "LOW": 2},
+		"findings": [{"name": "CVE-2024-0001", "severity": "HIGH", "uri": "https://example.com/CVE-2024-0001"}]}}`
+	testScanPage2 = `{"imageScanStatus": {"status": "COMPLETE"}, "imageScanFindings": {
+		"findingSeverityCounts": {"HIGH": 1, "LOW": 2},
+		"findings": [{"name": "CVE-2024-0002", "severity": "LOW"}, {"name": "CVE-2024-0003", "severity": "LOW"}]}}`
+	testScanEnhanced = `{"imageScanStatus": {"status": "ACTIVE"}, "imageScanFindings": {
+		"findingSeverityCounts": {"CRITICAL": 1},
+		"enhancedFindings": [{"title": "openssl", "severity": "CRITICAL",
+			"packageVulnerabilityDetails": {"vulnerabilityId": "CVE-2024-0004", "sourceUrl": "https://example.com/CVE-2024-0004"}}]}}`
+)
+
+// helper function to create an ECR client for the stand-in with the scan
+// responses of team/app.
+func newScanClient(t *testing.T, scans ...string) *ecr.Client {
+	t.Helper()
+	var calls []string
+	srv := newTestECR(t, &calls, map[string]*testRepo{"team/app": {Scans: scans}})
+	cfg := aws.Config{
+		Region:      defaultRegion,
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	}
+	svc, _ := getECRClient(cfg, roleConfig{}, srv.URL)
+	return svc
+}
+
+func TestWaitForScan(t *testing.T) {
+	defer func(interval time.Duration) { scanPollInterval = interval }(scanPollInterval)
+	scanPollInterval = time.Millisecond
+
+	svc := newScanClient(t, "ScanNotFoundException", `{"imageScanStatus": {"status": "IN_PROGRESS"}}`, testScanPage1, testScanPage2)
+	got, err := waitForScan(context.Background(), svc, "team/app", "sha256:abc", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := scanReport{
+		Repository: "team/app",
+		Digest:     "sha256:abc",
+		Status:     "COMPLETE",
+		Counts:     map[string]int32{"HIGH": 1, "LOW": 2},
+		Findings: []scanFinding{
+			{Name: "CVE-2024-0001", Severity: "HIGH", URI: "https://example.com/CVE-2024-0001"},
+			{Name: "CVE-2024-0002", Severity: "LOW"},
+			{Name: "CVE-2024-0003", Severity: "LOW"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if summary := got.summary(); summary != "HIGH=1 LOW=2" {
+		t.Errorf("got summary %q", summary)
+	}
+
+	svc = newScanClient(t, testScanEnhanced)
+	got, err = waitForScan(context.Background(), svc, "team/app", "sha256:abc", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := []scanFinding{{Name: "CVE-2024-0004", Severity: "CRITICAL", URI: "https://example.com/CVE-2024-0004"}}; !reflect.DeepEqual(got.Findings, want) {
+		t.Errorf("got enhanced findings %+v, want %+v", got.Findings, want)
+	}
+
+	svc = newScanClient(t, `{"imageScanStatus": {"status": "FAILED", "description": "unsupported layer"}}`)
+	if _, err := waitForScan(context.Background(), svc, "team/app", "sha256:abc", time.Minute); err == nil || !strings.Contains(err.Error(), "unsupported layer") {
+		t.Errorf("expected a failed scan error, got %v", err)
+	}
+
+	scanPollInterval = time.Second
+	svc = newScanClient(t, `{"imageScanStatus": {"status": "IN_PROGRESS"}}`)
+	if _, err := waitForScan(context.Background(), svc, "team/app", "sha256:abc", 50*time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+}
+
+func TestCheckScan(t *testing.T) {
+	dir := t.TempDir()
+	reportPath := filepath.Join(dir, "scan.json")
+	cardPath := filepath.Join(dir, "card.json")
+	os.WriteFile(cardPath, []byte(`{"schema": "https://drone-plugins.github.io/drone-docker/card.json", "data": {"URL": "https://gallery.ecr.aws/team/app"}}`), 0644)
+
+	threshold, _ := parseScanThreshold("CRITICAL,HIGH=0")
+	svc := newScanClient(t, testScanPage1, testScanPage2)
+	err := checkScan(context.Background(), svc, "team/app", "sha256:abc", time.Minute, threshold, reportPath, cardPath)
+	if err == nil || !strings.Contains(err.Error(), "  - 1 HIGH findings, 0 allowed") {
+		t.Errorf("expected a threshold error, got %v", err)
+	}
+
+	var report scanReport
+	data, _ := os.ReadFile(reportPath)
+	if err := json.Unmarshal(data, &report); err != nil || len(report.Findings) != 3 {
+		t.Errorf("got report %s", data)
+	}
+
+	var card droneclient.CardInput
+	data, _ = os.ReadFile(cardPath)
+	json.Unmarshal(data, &card)
+	var cardData struct {
+		URL          string
+		ScanFindings struct{ Summary string }
+	}
+	json.Unmarshal(card.Data, &cardData)
+	if cardData.URL != "https://gallery.ecr.aws/team/app" || cardData.ScanFindings.Summary != "HIGH=1 LOW=2" {
+		t.Errorf("got card %s", card.Data)
+	}
+
+	svc = newScanClient(t, testScanPage1, testScanPage2)
+	if err := checkScan(context.Background(), svc, "team/app", "sha256:abc", time.Minute, scanThreshold{"HIGH": 1}, "", ""); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestScanImageIndex(t *testing.T) {
+	defer func(interval time.Duration) { scanPollInterval = interval }(scanPollInterval)
+	scanPollInterval = time.Millisecond
+
+	index := `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.index.v1+json", "manifests": [
+		{"digest": "sha256:amd64", "platform": {"os": "linux", "architecture": "amd64"}},
+		{"digest": "sha256:arm64", "platform": {"os": "linux", "architecture": "arm64", "variant": "v8"}},
+		{"digest": "sha256:attestation", "platform": {"os": "unknown", "architecture": "unknown"}}
+	]}`
+	var calls []string
+	srv := newTestECR(t, &calls, map[string]*testRepo{"team/app": {
+		Manifests: map[string]string{"sha256:index": index},
+		ImageScans: map[string][]string{
+			"sha256:amd64": {testScanPage1, testScanPage2},
+			"sha256:arm64": {`{"imageScanStatus": {"status": "IN_PROGRESS"}}`, `{"imageScanStatus": {"status": "COMPLETE"}, "imageScanFindings": {
+				"findingSeverityCounts": {"HIGH": 2},
+				"findings": [{"name": "CVE-2024-0001", "severity": "HIGH", "uri": "https://example.com/CVE-2024-0001"}, {"name": "CVE-2024-0005", "severity": "HIGH"}]}}`},
+		},
+	}})
+	cfg := aws.Config{
+		Region:      defaultRegion,
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	}
+	svc, _ := getECRClient(cfg, roleConfig{}, srv.URL)
+
+	report, err := scanImage(context.Background(), svc, "team/app", "sha256:index", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := map[string]int32{"HIGH": 2, "LOW": 2}; !reflect.DeepEqual(report.Counts, want) {
+		t.Errorf("got counts %v, want %v", report.Counts, want)
+	}
+	var platforms, findings []string
+	for _, platform := range report.Platforms {
+		platforms = append(platforms, platform.Platform+"@"+platform.Digest+" "+platform.summary())
+	}
+	for _, f := range report.Findings {
+		findings = append(findings, f.Name)
+	}
+	if want := []string{"linux/amd64@sha256:amd64 HIGH=1 LOW=2", "linux/arm64/v8@sha256:arm64 HIGH=2"}; !reflect.DeepEqual(platforms, want) {
+		t.Errorf("got platforms %q, want %q", platforms, want)
+	}
+	if want := []string{"CVE-2024-0001", "CVE-2024-0002", "CVE-2024-0003", "CVE-2024-0005"}; !reflect.DeepEqual(findings, want) {
+		t.Errorf("got findings %q, want %q", findings, want)
+	}
+
+	// the index itself and the attestation are not scanned
+	want := []string{
+		"BatchGetImage team/app",
+		"DescribeImageScanFindings team/app",
+		"DescribeImageScanFindings team/app",
+		"DescribeImageScanFindings team/app",
+		"DescribeImageScanFindings team/app",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+
+	// the threshold applies to each platform, not to the sum of the findings
+	threshold, _ := parseScanThreshold("HIGH=1")
+	if problems, want := threshold.exceededReport(report), []string{"linux/arm64/v8: 2 HIGH findings, 1 allowed"}; !reflect.DeepEqual(problems, want) {
+		t.Errorf("got problems %q, want %q", problems, want)
+	}
+	threshold, _ = parseScanThreshold("HIGH=2")
+	if problems := threshold.exceededReport(report); len(problems) != 0 {
+		t.Errorf("expected no platform to exceed the threshold, got %q", problems)
+	}
+}
+
+func TestArtifactDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifact.json")
+	os.WriteFile(path, []byte(`{"kind": "docker/v1", "data": {"images": [
+		{"image": "000000000000.dkr.ecr.us-east-1.amazonaws.com/team/app-cache:1.0", "digest": "sha256:cache"},
+		{"image": "000000000000.dkr.ecr.us-east-1.amazonaws.com/team/app:1.0", "digest": "sha256:abc"}
+	]}}`), 0644)
+
+	digest, err := artifactDigest(path, "000000000000.dkr.ecr.us-east-1.amazonaws.com/team/app")
+	if err != nil || digest != "sha256:abc" {
+		t.Errorf("got digest %q error %v", digest, err)
+	}
+	if _, err := artifactDigest(path, "000000000000.dkr.ecr.us-east-1.amazonaws.com/team/other"); !errors.Is(err, errNothingPushed) {
+		t.Errorf("expected nothing pushed for a repository that was not pushed, got %v", err)
+	}
+	// pull requests and skipped tags do not write the artifact file
+	if _, err := artifactDigest(filepath.Join(t.TempDir(), "missing.json"), "000000000000.dkr.ecr.us-east-1.amazonaws.com/team/app"); !errors.Is(err, errNothingPushed) {
+		t.Errorf("expected nothing pushed without an artifact file, got %v", err)
+	}
+}
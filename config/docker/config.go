@@ -5,8 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 const (
@@ -17,19 +17,24 @@ const (
 
 type (
 	Auth struct {
-		Auth string `json:"auth"`
+		Auth          string `json:"auth,omitempty"`
+		IdentityToken string `json:"identitytoken,omitempty"`
+		RegistryToken string `json:"registrytoken,omitempty"`
 	}
 
 	Config struct {
 		Auths       map[string]Auth   `json:"auths"`
 		CredHelpers map[string]string `json:"credHelpers,omitempty"`
+		CredsStore  string            `json:"credsStore,omitempty"`
 	}
 )
 
 type RegistryCredentials struct {
-	Registry string
-	Username string
-	Password string
+	Registry      string
+	Username      string
+	Password      string
+	IdentityToken string // OAuth refresh token, used instead of the password
+	RegistryToken string // Bearer token sent to the registry as is
 }
 
 func NewConfig() *Config {
@@ -45,23 +50,109 @@ func (c *Config) SetAuth(registry, username, password string) {
 	c.Auths[registry] = Auth{Auth: encodedString}
 }
 
+func (c *Config) SetIdentityToken(registry, username, token string) {
+	auth := Auth{IdentityToken: token}
+	if username != "" {
+		auth.Auth = base64.StdEncoding.EncodeToString([]byte(username + ":"))
+	}
+	c.Auths[registry] = auth
+}
+
+func (c *Config) SetRegistryToken(registry, token string) {
+	c.Auths[registry] = Auth{RegistryToken: token}
+}
+
 func (c *Config) SetCredHelper(registry, helper string) {
 	c.CredHelpers[registry] = helper
 }
 
-func (c *Config) CreateDockerConfigJson(credentials []RegistryCredentials) ([]byte, error) {
+func (c *Config) SetCredsStore(store string) {
+	c.CredsStore = store
+}
+
+// MergeConfigJson merges the config into a user-provided config.json. Keys
+// of the user config that are not part of Config are kept as is. Auths and
+// credential helpers of the config are added to the user config, replacing
+// the entries of the same registry, and the credential store replaces the
+// user store when set.
+func (c *Config) MergeConfigJson(data []byte) ([]byte, error) {
+	user := map[string]json.RawMessage{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &user); err != nil {
+			return nil, fmt.Errorf("invalid docker config json: %s", err)
+		}
+	}
+
+	auths := map[string]json.RawMessage{}
+	if err := unmarshalKey(user, "auths", &auths); err != nil {
+		return nil, err
+	}
+	for registry, auth := range c.Auths {
+		raw, _ := json.Marshal(auth)
+		auths[registry] = raw
+	}
+	helpers := map[string]string{}
+	if err := unmarshalKey(user, "credHelpers", &helpers); err != nil {
+		return nil, err
+	}
+	for registry, helper := range c.CredHelpers {
+		helpers[registry] = helper
+	}
+
+	user["auths"], _ = json.Marshal(auths)
+	if len(helpers) > 0 {
+		user["credHelpers"], _ = json.Marshal(helpers)
+	}
+	if c.CredsStore != "" {
+		user["credsStore"], _ = json.Marshal(c.CredsStore)
+	}
+
+	jsonBytes, err := json.Marshal(user)
+	if err != nil {
+		return nil, errors.New("failed to serialize docker config json")
+	}
+	return jsonBytes, nil
+}
+
+// helper function to decode a top level key of a docker config json.
+func unmarshalKey(config map[string]json.RawMessage, key string, v interface{}) error {
+	raw, ok := config[key]
+	if !ok || string(raw) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid %s in docker config json: %s", key, err)
+	}
+	return nil
+}
+
+// AddCredentials adds the auths of the credentials to the config. When a
+// registry is listed more than once, the last credentials win.
+func (c *Config) AddCredentials(credentials []RegistryCredentials) error {
 	for _, cred := range credentials {
-		if cred.Registry != "" {
-
-			if cred.Username == "" {
-				return nil, fmt.Errorf("Username must be specified for registry: %s", cred.Registry)
-			}
-			if cred.Password == "" {
-				return nil, fmt.Errorf("Password must be specified for registry: %s", cred.Registry)
-			}
+		if cred.Registry == "" {
+			continue
+		}
+		switch {
+		case cred.RegistryToken != "":
+			c.SetRegistryToken(cred.Registry, cred.RegistryToken)
+		case cred.IdentityToken != "":
+			c.SetIdentityToken(cred.Registry, cred.Username, cred.IdentityToken)
+		case cred.Username == "":
+			return fmt.Errorf("Username must be specified for registry: %s", cred.Registry)
+		case cred.Password == "":
+			return fmt.Errorf("Password must be specified for registry: %s", cred.Registry)
+		default:
 			c.SetAuth(cred.Registry, cred.Username, cred.Password)
 		}
 	}
+	return nil
+}
+
+func (c *Config) CreateDockerConfigJson(credentials []RegistryCredentials) ([]byte, error) {
+	if err := c.AddCredentials(credentials); err != nil {
+		return nil, err
+	}
 
 	jsonBytes, err := json.Marshal(c)
 	if err != nil {
@@ -71,18 +162,20 @@ func (c *Config) CreateDockerConfigJson(credentials []RegistryCredentials) ([]by
 	return jsonBytes, nil
 }
 
-func WriteDockerConfig(data []byte, path string) (string error) {
-	err := os.MkdirAll(path, 0600)
-	if err != nil {
-		if !os.IsExist(err) {
-			return errors.New("failed to create %s directory")
-		}
+// WriteDockerConfig writes config.json to the directory path. The file holds
+// credentials, so it is only readable by the current user.
+func WriteDockerConfig(data []byte, path string) error {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return fmt.Errorf("failed to create %s directory: %s", path, err)
 	}
 
-	filePath := path + "/config.json"
-	err = ioutil.WriteFile(filePath, data, 0644)
-	if err != nil {
-		return errors.New("failed to create docker config file at %s")
+	filePath := filepath.Join(path, "config.json")
+	if err := os.WriteFile(filePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to create docker config file at %s: %s", filePath, err)
+	}
+	// the file may exist from an earlier step with wider permissions
+	if err := os.Chmod(filePath, 0600); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %s", filePath, err)
 	}
 	return nil
 }
@@ -62,3 +62,64 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, c.Auths, configFromFile.Auths)
 	assert.Equal(t, c.CredHelpers, configFromFile.CredHelpers)
 }
+
+func TestMergeConfigJson(t *testing.T) {
+	user := []byte(`{
+		"auths": {"gcr.io": {"auth": "dXNlcjpwYXNz"}, "ghcr.io": {"auth": "b2xkOm9sZA=="}},
+		"credHelpers": {"gcr.io": "gcloud"},
+		"proxies": {"default": {"httpProxy": "http://proxy:3128"}}
+	}`)
+
+	c := NewConfig()
+	c.SetAuth("ghcr.io", "new", "new")
+	c.SetCredHelper(RegistryECRPublic, "ecr-login")
+	c.SetCredsStore("pass")
+
+	data, err := c.MergeConfigJson(user)
+	assert.NoError(t, err)
+
+	var merged struct {
+		Auths       map[string]Auth            `json:"auths"`
+		CredHelpers map[string]string          `json:"credHelpers"`
+		CredsStore  string                     `json:"credsStore"`
+		Proxies     map[string]json.RawMessage `json:"proxies"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &merged))
+	assert.Equal(t, map[string]Auth{
+		"gcr.io":  {Auth: "dXNlcjpwYXNz"},
+		"ghcr.io": {Auth: "bmV3Om5ldw=="},
+	}, merged.Auths)
+	assert.Equal(t, map[string]string{"gcr.io": "gcloud", RegistryECRPublic: "ecr-login"}, merged.CredHelpers)
+	assert.Equal(t, "pass", merged.CredsStore)
+	assert.Contains(t, merged.Proxies, "default")
+
+	_, err = c.MergeConfigJson([]byte(`{"auths": []}`))
+	assert.Error(t, err)
+}
+
+func TestAddCredentialsTokens(t *testing.T) {
+	c := NewConfig()
+	err := c.AddCredentials([]RegistryCredentials{
+		{Registry: "myregistry.azurecr.io", Username: "00000000-0000-0000-0000-000000000000", IdentityToken: "refresh"},
+		{Registry: "harbor.example.com", RegistryToken: "bearer"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, Auth{Auth: "MDAwMDAwMDAtMDAwMC0wMDAwLTAwMDAtMDAwMDAwMDAwMDAwOg==", IdentityToken: "refresh"}, c.Auths["myregistry.azurecr.io"])
+	assert.Equal(t, Auth{RegistryToken: "bearer"}, c.Auths["harbor.example.com"])
+
+	err = c.AddCredentials([]RegistryCredentials{{Registry: "gcr.io", Username: "user"}})
+	assert.Error(t, err)
+}
+
+func TestWriteDockerConfig(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), ".docker")
+	assert.NoError(t, WriteDockerConfig([]byte(`{"auths":{}}`), dir))
+
+	info, err := os.Stat(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Join(dir, "config.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
@@ -0,0 +1,87 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/drone-plugins/drone-buildx/config/docker"
+)
+
+// credHelperPrefix is the prefix of the credential helper binaries.
+const credHelperPrefix = "docker-credential-"
+
+// ParseCredHelpers parses registry=helper pairs such as
+// 123456789012.dkr.ecr.us-east-1.amazonaws.com=ecr-login.
+func ParseCredHelpers(pairs []string) (map[string]string, error) {
+	helpers := map[string]string{}
+	for _, pair := range pairs {
+		registry, helper, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || registry == "" || helper == "" {
+			return nil, fmt.Errorf("invalid credential helper %q, expected registry=helper", pair)
+		}
+		helpers[strings.TrimSpace(registry)] = credHelperName(helper)
+	}
+	return helpers, nil
+}
+
+// helper function that returns the name of a credential helper as used in
+// config.json, without the binary prefix.
+func credHelperName(helper string) string {
+	return strings.TrimPrefix(strings.TrimSpace(helper), credHelperPrefix)
+}
+
+// credHelperCredentials returns the credentials a credential helper holds for
+// server, the registry as it is written in config.json. A helper without
+// credentials for the registry returns no credentials.
+func (p Plugin) credHelperCredentials(ctx context.Context, helper, server, registry string) docker.RegistryCredentials {
+	creds := docker.RegistryCredentials{Registry: registry}
+	cmd := exec.CommandContext(ctx, credHelperPrefix+credHelperName(helper), "get")
+	cmd.Stdin = strings.NewReader(server)
+	res, err := p.executor().Run(ctx, cmd)
+	if err != nil {
+		// helpers report missing credentials on stdout
+		if !strings.Contains(res.Stdout+res.Stderr, "credentials not found") {
+			fmt.Printf("Warning: credential helper %s failed for %s: %s\n", helper, server, err)
+		}
+		return creds
+	}
+	var result struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal([]byte(res.Stdout), &result); err != nil {
+		fmt.Printf("Warning: invalid credentials from credential helper %s: %s\n", helper, err)
+		return creds
+	}
+	// identity tokens are returned with the <token> username
+	if result.Username == "<token>" {
+		creds.IdentityToken = result.Secret
+		return creds
+	}
+	creds.Username, creds.Password = result.Username, result.Secret
+	return creds
+}
+
+// missingCredHelpers returns the configured credential helpers and store
+// whose binary is not installed.
+func (p Plugin) missingCredHelpers() []string {
+	var missing []string
+	helpers := []string{}
+	for _, helper := range p.Login.CredHelpers {
+		helpers = append(helpers, credHelperName(helper))
+	}
+	if p.Login.CredsStore != "" {
+		helpers = append(helpers, credHelperName(p.Login.CredsStore))
+	}
+	for _, helper := range helpers {
+		if _, err := exec.LookPath(credHelperPrefix + helper); err != nil && !contains(missing, helper) {
+			missing = append(missing, helper)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,111 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/drone-plugins/drone-buildx/config/docker"
+)
+
+func TestParseCredHelpers(t *testing.T) {
+	got, err := ParseCredHelpers([]string{
+		"123456789012.dkr.ecr.us-east-1.amazonaws.com=ecr-login",
+		" gcr.io = docker-credential-gcloud",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := map[string]string{
+		"123456789012.dkr.ecr.us-east-1.amazonaws.com": "ecr-login",
+		"gcr.io": "gcloud",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := ParseCredHelpers([]string{"gcr.io"}); err == nil {
+		t.Error("expected an error for a pair without helper")
+	}
+}
+
+func TestDockerConfigCredHelpers(t *testing.T) {
+	p := Plugin{
+		Login: Login{
+			Config:      `{"auths": {"registry.example.com": {"auth": "dXNlcjpwYXNz"}}}`,
+			CredHelpers: map[string]string{"myregistry.azurecr.io": "acr-env"},
+			CredsStore:  "docker-credential-pass",
+		},
+	}
+	data, err := p.dockerConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var config struct {
+		Auths       map[string]interface{} `json:"auths"`
+		CredHelpers map[string]string      `json:"credHelpers"`
+		CredsStore  string                 `json:"credsStore"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatalf("invalid config: %s", err)
+	}
+	if _, ok := config.Auths["registry.example.com"]; !ok {
+		t.Errorf("user auths were not kept: %s", data)
+	}
+	if config.CredHelpers["myregistry.azurecr.io"] != "acr-env" {
+		t.Errorf("credential helper missing: %s", data)
+	}
+	if config.CredsStore != "pass" {
+		t.Errorf("got credsStore %q, want pass", config.CredsStore)
+	}
+
+	if missing := p.missingCredHelpers(); !reflect.DeepEqual(missing, []string{"acr-env", "pass"}) {
+		t.Errorf("got missing helpers %q", missing)
+	}
+}
+
+func TestCredHelperCredentials(t *testing.T) {
+	bin := t.TempDir()
+	helper := `#!/bin/sh
+test "$1" = get || exit 2
+case "$(cat)" in
+gcr.io) echo '{"ServerURL": "gcr.io", "Username": "_json_key", "Secret": "gcr-secret"}' ;;
+https://index.docker.io/v1/) echo '{"ServerURL": "https://index.docker.io/v1/", "Username": "<token>", "Secret": "refresh"}' ;;
+*) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(bin, credHelperPrefix+"test"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{
+		"auths": {
+			"https://index.docker.io/v1/": {},
+			"registry.example.com": {"username": "robot", "password": "token"},
+			"gcr.io": {"username": "stale", "password": "stale"}
+		},
+		"credHelpers": {"gcr.io": "test"},
+		"credsStore": "test"
+	}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []docker.RegistryCredentials{
+		{Registry: "gcr.io", Username: "_json_key", Password: "gcr-secret"},
+		{Registry: "docker.io", IdentityToken: "refresh"},
+		{Registry: "registry.example.com", Username: "robot", Password: "token"},
+		{Registry: "quay.io"},
+	}
+	for _, want := range tests {
+		if got := (Plugin{}).dockerConfigCredentials(context.Background(), path, want.Registry); got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
@@ -6,13 +6,17 @@ package docker
 import (
 	"io"
 	"os"
+	"syscall"
+	"time"
 )
 
 const dockerExe = "docker"
 const dockerdExe = "dockerd"
 const dockerHome = "/root/.docker/"
 
-func (p Plugin) startDaemon() {
+// startDaemon starts dockerd in the background and returns a function that
+// stops it, killing the daemon if it does not exit within the grace period.
+func (p Plugin) startDaemon() func(time.Duration) {
 	cmd := commandDaemon(p.Daemon)
 	if p.Daemon.Debug {
 		cmd.Stdout = os.Stdout
@@ -21,8 +25,21 @@ func (p Plugin) startDaemon() {
 		cmd.Stdout = io.Discard
 		cmd.Stderr = io.Discard
 	}
+	trace(cmd)
+	if err := cmd.Start(); err != nil {
+		return func(time.Duration) {}
+	}
+	done := make(chan struct{})
 	go func() {
-		trace(cmd)
-		cmd.Run()
+		cmd.Wait()
+		close(done)
 	}()
+	return func(grace time.Duration) {
+		stopProcess(cmd.Process, done, grace)
+	}
+}
+
+// terminateProcess asks the process to exit gracefully.
+func terminateProcess(proc *os.Process) error {
+	return proc.Signal(syscall.SIGTERM)
 }
@@ -3,10 +3,22 @@
 
 package docker
 
+import (
+	"os"
+	"time"
+)
+
 const dockerExe = "C:\\Windows\\system32\\docker.exe"
 const dockerdExe = ""
 const dockerHome = "C:\\ProgramData\\docker\\"
 
-func (p Plugin) startDaemon() {
+func (p Plugin) startDaemon() func(time.Duration) {
 	// this is a no-op on windows
+	return func(time.Duration) {}
+}
+
+// terminateProcess kills the process, windows does not support sending
+// SIGTERM to a child process.
+func terminateProcess(proc *os.Process) error {
+	return proc.Kill()
 }
@@ -0,0 +1,128 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+	"gopkg.in/yaml.v3"
+)
+
+// Destination is an additional repository the image is pushed to. The
+// destinations are set with PLUGIN_DESTINATIONS as a JSON or YAML list.
+type Destination struct {
+	Repo          string   `yaml:"repo"`           // Repository, including the registry host
+	Tags          []string `yaml:"tags"`           // Tags, the build tags when empty
+	Registry      string   `yaml:"registry"`       // Registry to log in to, the host of the repository when empty
+	Username      string   `yaml:"username"`       // Registry username
+	Password      string   `yaml:"password"`       // Registry password
+	PasswordEnv   string   `yaml:"password_env"`   // Environment variable holding the registry password
+	IdentityToken string   `yaml:"identity_token"` // OAuth refresh token, used instead of the password
+}
+
+// ParseDestinations parses the JSON or YAML list of destinations. Passwords
+// given with password_env are read from the environment.
+func ParseDestinations(s string) ([]Destination, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var destinations []Destination
+	// JSON is a subset of YAML, so both formats are decoded the same way
+	dec := yaml.NewDecoder(strings.NewReader(s))
+	dec.KnownFields(true)
+	if err := dec.Decode(&destinations); err != nil {
+		return nil, fmt.Errorf("invalid destinations: %s", err)
+	}
+	for i, d := range destinations {
+		if d.PasswordEnv == "" || d.Password != "" {
+			continue
+		}
+		if destinations[i].Password = os.Getenv(d.PasswordEnv); destinations[i].Password == "" {
+			return nil, fmt.Errorf("password for destination %s: environment variable %s is empty", d.Repo, d.PasswordEnv)
+		}
+	}
+	return destinations, nil
+}
+
+// registry returns the registry host of the destination.
+func (d Destination) registry() string {
+	if d.Registry != "" {
+		return normalizeRegistry(d.Registry)
+	}
+	ref, err := parseImageRef(d.Repo)
+	if err != nil {
+		return dockerHubRegistry
+	}
+	return ref.Registry
+}
+
+// targets returns every repository the image is pushed to with its tags,
+// starting with the build repository.
+func (p Plugin) targets() []Destination {
+	return append([]Destination{{Repo: p.Build.Repo, Tags: p.Build.Tags}}, p.Destinations...)
+}
+
+// helper function to set the targets returned by targets after their tags
+// were changed.
+func (p *Plugin) setTargets(targets []Destination) {
+	p.Build.Tags = targets[0].Tags
+	p.Destinations = targets[1:]
+	p.Build.ExtraTags = nil
+	for _, d := range p.Destinations {
+		for _, tag := range d.Tags {
+			p.Build.ExtraTags = append(p.Build.ExtraTags, fmt.Sprintf("%s:%s", d.Repo, tag))
+		}
+	}
+}
+
+// loginDestinations logs in to the registry of every destination with
+// credentials, except the registries configured in config.json.
+func (p Plugin) loginDestinations(ctx context.Context, configured map[string]bool) error {
+	for _, d := range p.Destinations {
+		if d.Password == "" || configured[d.registry()] {
+			continue
+		}
+		login := Login{Registry: d.registry(), Username: d.Username, Password: d.Password}
+		if err := p.login(ctx, "destination registry", commandLogin(login)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeArtifactFile writes the images of every target with their digests to
+// the artifact file. Targets without a digest are left out.
+func (p Plugin) writeArtifactFile(targets []Destination, digests []string) error {
+	var images []drone.Image
+	for i, target := range targets {
+		if digests[i] == "" {
+			continue
+		}
+		for _, tag := range target.Tags {
+			images = append(images, drone.Image{Image: fmt.Sprintf("%s:%s", target.Repo, tag), Digest: digests[i]})
+		}
+	}
+	if len(images) == 0 {
+		return fmt.Errorf("no image digest found")
+	}
+	artifact := drone.DockerArtifact{
+		Kind: "docker/v1",
+		Data: drone.Data{
+			RegistryType: p.Daemon.RegistryType,
+			RegistryURL:  p.Daemon.ArtifactRegistry,
+			Images:       images,
+		},
+	}
+	data, err := json.MarshalIndent(artifact, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.ArtifactFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p.ArtifactFile, data, 0644)
+}
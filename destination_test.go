@@ -0,0 +1,144 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drone-plugins/drone-plugin-lib/drone"
+)
+
+func TestParseDestinations(t *testing.T) {
+	t.Setenv("HARBOR_PASSWORD", "hunter2")
+	got, err := ParseDestinations(`[
+		{"repo": "octocat/app"},
+		{"repo": "harbor.example.com/team/app", "tags": ["1.0"], "username": "robot", "password_env": "HARBOR_PASSWORD"}
+	]`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []Destination{
+		{Repo: "octocat/app"},
+		{Repo: "harbor.example.com/team/app", Tags: []string{"1.0"}, Username: "robot", Password: "hunter2", PasswordEnv: "HARBOR_PASSWORD"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got[0].registry() != "docker.io" || got[1].registry() != "harbor.example.com" {
+		t.Errorf("got registries %s and %s", got[0].registry(), got[1].registry())
+	}
+
+	yamlList, err := ParseDestinations(`
+- repo: octocat/app
+- repo: harbor.example.com/team/app
+  tags: ["1.0"]
+  username: robot
+  password_env: HARBOR_PASSWORD
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(yamlList, want) {
+		t.Errorf("got %+v, want %+v", yamlList, want)
+	}
+
+	for _, s := range []string{
+		`[{"repo": "octocat/app", "pasword": "typo"}]`,
+		`[{"repo": "octocat/app", "username": "robot", "password_env": "MISSING_PASSWORD"}]`,
+	} {
+		if _, err := ParseDestinations(s); err == nil {
+			t.Errorf("expected an error for %s", s)
+		}
+	}
+}
+
+func TestCommandBuildxDestinations(t *testing.T) {
+	p := Plugin{
+		Build: Build{Repo: "octocat/app", Tags: []string{"latest", "1.0"}, Context: "."},
+		Destinations: []Destination{
+			{Repo: "harbor.example.com/team/app", Tags: []string{"1.0"}},
+		},
+	}
+	p.setTargets(p.targets())
+
+	cmd := commandBuildx(p.Build, p.Builder, false, "", "", "")
+	want := "-t octocat/app:latest -t octocat/app:1.0 -t harbor.example.com/team/app:1.0 --push"
+	if !strings.Contains(cmd.String(), want) {
+		t.Errorf("got command %q, want it to contain %q", cmd.String(), want)
+	}
+}
+
+func TestPushOnlyDestinations(t *testing.T) {
+	transcript := strings.Join([]string{
+		`{"args":["docker","image","inspect","source:1.0"],"exit_code":0}`,
+		`{"args":["docker","tag","source:1.0","octocat/app:latest"],"exit_code":0}`,
+		`{"args":["docker","tag","source:1.0","harbor.example.com/team/app:1.0"],"exit_code":0}`,
+		`{"args":["docker","image","inspect","octocat/app:latest"],"exit_code":0}`,
+		`{"args":["docker","push","octocat/app:latest"],"exit_code":0}`,
+		`{"args":["docker","image","inspect","harbor.example.com/team/app:1.0"],"exit_code":0}`,
+		`{"args":["docker","push","harbor.example.com/team/app:1.0"],"exit_code":0}`,
+	}, "\n")
+
+	e, err := NewReplayExecutor(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatalf("unable to read transcript: %s", err)
+	}
+
+	p := Plugin{
+		SourceImage: "source:1.0",
+		Build: Build{
+			Repo: "octocat/app",
+			Tags: []string{"latest"},
+		},
+		Destinations: []Destination{
+			{Repo: "harbor.example.com/team/app", Tags: []string{"1.0"}},
+		},
+		Executor: e,
+	}
+	if err := p.pushOnly(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if remaining := e.Remaining(); len(remaining) != 0 {
+		t.Errorf("Commands not executed: %+v", remaining)
+	}
+}
+
+func TestWriteArtifactFile(t *testing.T) {
+	p := Plugin{
+		ArtifactFile: filepath.Join(t.TempDir(), "artifact", "image.json"),
+		Daemon:       Daemon{RegistryType: drone.Docker, ArtifactRegistry: "https://index.docker.io/v1/"},
+	}
+	targets := []Destination{
+		{Repo: "octocat/app", Tags: []string{"latest", "1.0"}},
+		{Repo: "harbor.example.com/team/app", Tags: []string{"1.0"}},
+		{Repo: "gcr.io/team/app", Tags: []string{"1.0"}},
+	}
+	if err := p.writeArtifactFile(targets, []string{"sha256:aaa", "sha256:bbb", ""}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	data, err := os.ReadFile(p.ArtifactFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var artifact drone.DockerArtifact
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		t.Fatal(err)
+	}
+	want := []drone.Image{
+		{Image: "octocat/app:latest", Digest: "sha256:aaa"},
+		{Image: "octocat/app:1.0", Digest: "sha256:aaa"},
+		{Image: "harbor.example.com/team/app:1.0", Digest: "sha256:bbb"},
+	}
+	if !reflect.DeepEqual(artifact.Data.Images, want) {
+		t.Errorf("got images %+v, want %+v", artifact.Data.Images, want)
+	}
+
+	if err := p.writeArtifactFile(targets, []string{"", "", ""}); err == nil {
+		t.Error("expected an error without digests")
+	}
+}
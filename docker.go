@@ -2,9 +2,11 @@ package docker
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -33,7 +35,7 @@ type (
 		IPv6             bool               // Docker daemon IPv6 networking
 		RegistryType     drone.RegistryType // Docker registry type
 		ArtifactRegistry string             // Docker registry where artifact can be viewed
-		RetryCount    int                // Number of retry attempts to reach Docker daemon
+		RetryCount       int                // Number of retry attempts to reach Docker daemon
 	}
 
 	Builder struct {
@@ -52,58 +54,61 @@ type (
 
 	// Login defines Docker login parameters.
 	Login struct {
-		Registry    string // Docker registry address
-		Username    string // Docker registry username
-		Password    string // Docker registry password
-		Email       string // Docker registry email
-		Config      string // Docker Auth Config
-		AccessToken string // External Access Token
+		Registry    string            // Docker registry address
+		Username    string            // Docker registry username
+		Password    string            // Docker registry password
+		Email       string            // Docker registry email
+		Config      string            // Docker Auth Config
+		AccessToken string            // External Access Token
+		CredHelpers map[string]string // Docker credential helpers by registry
+		CredsStore  string            // Docker credential store for the other registries
 	}
 
 	// Build defines Docker build parameters.
 	Build struct {
-		Remote                       string   // Git remote URL
-		Name                         string   // Docker build using default named tag
-		Dockerfile                   string   // Docker build Dockerfile
-		Context                      string   // Docker build context
-		Tags                         []string // Docker build tags
-		Args                         []string // Docker build args
-		ArgsEnv                      []string // Docker build args from env
-		ArgsNew                      []string // Docker build args with comma seperated values
-		IsMultipleBuildArgs          bool     // env variable for fall back
-		Target                       string   // Docker build target
-		Squash                       bool     // Docker build squash
-		Pull                         bool     // Docker build pull
-		CacheFrom                    []string // Docker buildx cache-from
-		CacheTo                      []string // Docker buildx cache-to
-		CacheTlsInsecure             bool     // Docker buildx cache-tls-insecure
-		PathStyle                    bool     // Docker buildx path-style for s3 DLC
-		Compress                     bool     // Docker build compress
-		Repo                         string   // Docker build repository
-		LabelSchema                  []string // label-schema Label map
-		AutoLabel                    bool     // auto-label bool
-		Labels                       []string // Label map
-		Link                         string   // Git repo link
-		NoCache                      bool     // Docker build no-cache
-		Secret                       string   // secret keypair
-		SecretEnvs                   []string // Docker build secrets with env var as source
-		SecretFiles                  []string // Docker build secrets with file as source
-		AddHost                      []string // Docker build add-host
-		Quiet                        bool     // Docker build quiet
-		Platform                     string   // Docker build platform
-		SSHAgentKey                  string   // Docker build ssh agent key
-		SSHKeyPath                   string   // Docker build ssh key path
-		BuildxLoad                   bool     // Docker buildx --load
-		HarnessSelfHostedS3AccessKey      string // Harness self-hosted s3 access key
-		HarnessSelfHostedS3SecretKey      string // Harness self-hosted s3 secret key
-		HarnessSelfHostedGcpJsonKey       string // Harness self hosted gcp json key
-		HarnessSelfHostedAzureAccountKey  string // Harness self-hosted azure account key
-		HarnessSelfHostedAzureTenantID    string // Harness self-hosted azure tenant id
-		HarnessSelfHostedAzureClientID    string // Harness self-hosted azure client id
-		HarnessSelfHostedAzureClientSecret string // Harness self-hosted azure client secret
-		HarnessSelfHostedAzureOidcToken   string // Harness self-hosted azure oidc token
-		BuildxOptions                []string // Generic buildx options passed directly to the buildx command
-		BuildxOptionsSemicolon       string   // Buildx options separated by semicolons instead of commas
+		Remote                             string   // Git remote URL
+		Name                               string   // Docker build using default named tag
+		Dockerfile                         string   // Docker build Dockerfile
+		Context                            string   // Docker build context
+		Tags                               []string // Docker build tags
+		ExtraTags                          []string // Fully qualified tags of additional destinations
+		Args                               []string // Docker build args
+		ArgsEnv                            []string // Docker build args from env
+		ArgsNew                            []string // Docker build args with comma seperated values
+		IsMultipleBuildArgs                bool     // env variable for fall back
+		Target                             string   // Docker build target
+		Squash                             bool     // Docker build squash
+		Pull                               bool     // Docker build pull
+		CacheFrom                          []string // Docker buildx cache-from
+		CacheTo                            []string // Docker buildx cache-to
+		CacheTlsInsecure                   bool     // Docker buildx cache-tls-insecure
+		PathStyle                          bool     // Docker buildx path-style for s3 DLC
+		Compress                           bool     // Docker build compress
+		Repo                               string   // Docker build repository
+		LabelSchema                        []string // label-schema Label map
+		AutoLabel                          bool     // auto-label bool
+		Labels                             []string // Label map
+		Link                               string   // Git repo link
+		NoCache                            bool     // Docker build no-cache
+		Secret                             string   // secret keypair
+		SecretEnvs                         []string // Docker build secrets with env var as source
+		SecretFiles                        []string // Docker build secrets with file as source
+		AddHost                            []string // Docker build add-host
+		Quiet                              bool     // Docker build quiet
+		Platform                           string   // Docker build platform
+		SSHAgentKey                        string   // Docker build ssh agent key
+		SSHKeyPath                         string   // Docker build ssh key path
+		BuildxLoad                         bool     // Docker buildx --load
+		HarnessSelfHostedS3AccessKey       string   // Harness self-hosted s3 access key
+		HarnessSelfHostedS3SecretKey       string   // Harness self-hosted s3 secret key
+		HarnessSelfHostedGcpJsonKey        string   // Harness self hosted gcp json key
+		HarnessSelfHostedAzureAccountKey   string   // Harness self-hosted azure account key
+		HarnessSelfHostedAzureTenantID     string   // Harness self-hosted azure tenant id
+		HarnessSelfHostedAzureClientID     string   // Harness self-hosted azure client id
+		HarnessSelfHostedAzureClientSecret string   // Harness self-hosted azure client secret
+		HarnessSelfHostedAzureOidcToken    string   // Harness self-hosted azure oidc token
+		BuildxOptions                      []string // Generic buildx options passed directly to the buildx command
+		BuildxOptionsSemicolon             string   // Buildx options separated by semicolons instead of commas
 		// Buildx Bake (opt-in)
 		BakeFile    string // Buildx Bake definition file (HCL/JSON/Compose). If set, Bake mode is active
 		BakeOptions string // Semicolon-delimited Bake options and/or target names
@@ -111,25 +116,37 @@ type (
 
 	// Plugin defines the Docker plugin parameters.
 	Plugin struct {
-		Login               Login   // Docker login configuration
-		Build               Build   // Docker build configuration
-		Builder             Builder // Docker Buildx builder configuration
-		Daemon              Daemon  // Docker daemon configuration
-		Dryrun              bool    // Docker push is skipped
-		Cleanup             bool    // Docker purge is enabled
-		CardPath            string  // Card path to write file to
-		MetadataFile        string  // Location to write the metadata file
-		ArtifactFile        string  // Artifact path to write file to
-		CacheMetricsFile    string  // Location to write the cache metrics file
-		BaseImageRegistry   string  // Docker registry to pull base image
-		BaseImageUsername   string  // Docker registry username to pull base image
-		BaseImagePassword   string  // Docker registry password to pull base image
-		PushOnly            bool    // Push only mode, skips build process
-		SourceTarPath       string  // Path to Docker image tar file to load and push
-		TarPath             string  // Path to save Docker image as tar file
-		BuildxOutputFormat  string  // Buildx output format for direct tar output (docker, oci)
-		SourceImage         string  // Source image to push (optional)
-		BuildkitInheritAuth bool    // Inherit auth from docker daemon
+		Login               Login          // Docker login configuration
+		Build               Build          // Docker build configuration
+		Builder             Builder        // Docker Buildx builder configuration
+		Daemon              Daemon         // Docker daemon configuration
+		Dryrun              bool           // Docker push is skipped
+		Cleanup             bool           // Docker purge is enabled
+		CleanupPolicy       []string       // Purge policies: tagged, cache, dangling or none
+		CleanupKeepStorage  string         // Build cache to keep when pruning with the cache policy
+		CardPath            string         // Card path to write file to
+		MetadataFile        string         // Location to write the metadata file
+		ArtifactFile        string         // Artifact path to write file to
+		CacheMetricsFile    string         // Location to write the cache metrics file
+		BaseImageRegistry   string         // Docker registry to pull base image
+		BaseImageUsername   string         // Docker registry username to pull base image
+		BaseImagePassword   string         // Docker registry password to pull base image
+		PullRegistries      []PullRegistry // Additional registries to pull images from
+		PushOnly            bool           // Push only mode, skips build process
+		SourceTarPath       string         // Path to Docker image tar file to load and push
+		TarPath             string         // Path to save Docker image as tar file
+		BuildxOutputFormat  string         // Buildx output format for direct tar output (docker, oci)
+		SourceImage         string         // Source image to push (optional)
+		BuildkitInheritAuth bool           // Inherit auth from docker daemon
+		Executor            Executor       // Runs docker commands, defaults to the host executor
+		Timeout             time.Duration  // Maximum duration of the whole step, zero disables the timeout
+		GracePeriod         time.Duration  // Time given to cancelled commands to exit before they are killed
+		Strict              bool           // Configuration warnings fail the step
+		ImmutableTags       string         // Existing tags are protected: fail or skip
+		MutableTags         []string       // Tag patterns that may always be overwritten
+		VerifyPush          bool           // Pushed tags are verified in the registry
+		Destinations        []Destination  // Additional repositories the image is pushed to
+		PlanOutput          io.Writer      // Writer the plan is written to, stdout when nil
 	}
 
 	Card []struct {
@@ -172,10 +189,35 @@ const (
 
 // Exec executes the plugin step
 func (p Plugin) Exec() error {
+	return p.ExecContext(context.Background())
+}
+
+// ExecContext executes the plugin step. When ctx is cancelled or the step
+// timeout elapses the running command is terminated, the buildx builder is
+// removed and the Docker daemon is stopped, in that order.
+func (p Plugin) ExecContext(ctx context.Context) error {
+	p.registerSecrets()
+
+	// reject invalid configuration before anything is started
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, p.Timeout, errStepTimeout)
+		defer cancel()
+	}
 
 	// start the Docker daemon server
 	if !p.Daemon.Disabled {
-		p.startDaemon()
+		stopDaemon := p.startDaemon()
+		defer func() {
+			if ctx.Err() != nil {
+				fmt.Println("Stopping Docker daemon")
+				stopDaemon(p.gracePeriod())
+			}
+		}()
 	}
 	// poll the docker daemon until it is started. This ensures the daemon is
 	// ready to accept connections before we proceed.
@@ -184,8 +226,7 @@ func (p Plugin) Exec() error {
 		maxAttempts = 15 // default value
 	}
 	for i := 0; ; i++ {
-		cmd := commandInfo()
-		err := cmd.Run()
+		err := runCommand(ctx, p.executor(), commandInfo())
 		if err == nil {
 			break
 		}
@@ -193,7 +234,11 @@ func (p Plugin) Exec() error {
 			fmt.Printf("Unable to reach Docker Daemon after %d attempts.\n", maxAttempts)
 			break
 		}
-		time.Sleep(time.Second * 1)
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(time.Second * 1):
+		}
 	}
 	// for debugging purposes, log the type of authentication
 	// credentials that have been provided.
@@ -209,83 +254,46 @@ func (p Plugin) Exec() error {
 	default:
 		fmt.Println("Registry credentials or Docker config not provided. Guest mode enabled.")
 	}
-	// create Auth Config File
-	if p.Login.Config != "" {
-		os.MkdirAll(dockerHome, 0600)
-
-		path := filepath.Join(dockerHome, "config.json")
-		var content []byte
-		// Try to parse as JSON first to determine if it's content or file path
-		var jsonTest interface{}
-		if json.Unmarshal([]byte(p.Login.Config), &jsonTest) == nil {
-			// Valid JSON, treat as content
-			content = []byte(p.Login.Config)
-		} else if _, err := os.Stat(p.Login.Config); err == nil {
-			// Not JSON but file exists, read it
-			data, err := os.ReadFile(p.Login.Config)
-			if err != nil {
-				return fmt.Errorf("Error reading docker config file '%s': %s", p.Login.Config, err)
-			}
-			content = data
-		} else {
-			// Neither valid JSON nor existing file
-			return fmt.Errorf("Docker config must be either valid JSON content or a path to an existing config file")
+	// create Auth Config File with every credential. Registries with auths
+	// in the file need no docker login, which would rewrite the file when a
+	// credential store is set.
+	var configured map[string]bool
+	if p.needsDockerConfig() {
+		content, err := p.dockerConfig()
+		if err != nil {
+			return err
 		}
-		if err := os.WriteFile(path, content, 0600); err != nil {
-			return fmt.Errorf("Error writing config.json: %s", err)
+		if !p.planning() {
+			if err := docker.WriteDockerConfig(content, dockerConfigDir()); err != nil {
+				return fmt.Errorf("Error writing config.json: %s", err)
+			}
 		}
+		configured = configuredRegistries(content)
 	}
 
-	// add base image docker credentials to the existing config file, else create new
-	// instead of writing to config file directly, using docker's login func
-	if p.BaseImageRegistry != "" {
-		if p.BaseImageUsername == "" {
-			fmt.Printf("Username cannot be empty. The base image connector requires authenticated access. Please either use an authenticated connector, or remove the base image connector.")
-		}
-		if p.BaseImagePassword == "" {
-			fmt.Printf("Password cannot be empty. The base image connector requires authenticated access. Please either use an authenticated connector, or remove the base image connector.")
-		}
-		var baseConnectorLogin Login
-		baseConnectorLogin.Registry = p.BaseImageRegistry
-		baseConnectorLogin.Username = p.BaseImageUsername
-		baseConnectorLogin.Password = p.BaseImagePassword
-
-		cmd := commandLogin(baseConnectorLogin)
-
-		raw, err := cmd.CombinedOutput()
-		if err != nil {
-			out := string(raw)
-			out = strings.Replace(out, "WARNING! Using --password via the CLI is insecure. Use --password-stdin.", "", -1)
-			fmt.Println(out)
-			return fmt.Errorf("Error authenticating base connector: exit status 1")
-		}
-
-	} else {
+	// login to the registries base images are pulled from
+	if err := p.loginPullRegistries(ctx, configured); err != nil {
+		return err
+	}
+	if p.pullsFromDockerHub() {
 		fmt.Println("\033[33mTo ensure consistent and reliable pipeline execution, we recommend setting up a Base Image Connector.\033[0m\n" +
 			"\033[33mWhile optional at this time, configuring it helps prevent failures caused by Docker Hub's rate limits.\033[0m")
 	}
 	// login to the Docker registry
-	if p.Login.Password != "" {
-		cmd := commandLogin(p.Login)
-		raw, err := cmd.CombinedOutput()
-		if err != nil {
-			out := string(raw)
-			out = strings.Replace(out, "WARNING! Using --password via the CLI is insecure. Use --password-stdin.", "", -1)
-			fmt.Println(out)
-			return fmt.Errorf("Error authenticating: exit status 1")
-		}
-	} else if p.Login.AccessToken != "" {
-		cmd := commandLoginAccessToken(p.Login, p.Login.AccessToken)
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("error logging in to Docker registry: %s", err)
+	switch {
+	case configured[normalizeRegistry(p.Login.Registry)]:
+	case p.Login.Password != "":
+		if err := p.login(ctx, "registry", commandLogin(p.Login)); err != nil {
+			return err
 		}
-		if strings.Contains(string(output), "Login Succeeded") {
-			fmt.Println("Login successful")
-		} else {
-			return fmt.Errorf("login did not succeed")
+	case p.Login.AccessToken != "":
+		if err := p.login(ctx, "registry", commandLoginAccessToken(p.Login, p.Login.AccessToken)); err != nil {
+			return err
 		}
 	}
+	if err := p.loginDestinations(ctx, configured); err != nil {
+		return err
+	}
 
 	// cache export feature is currently not supported for docker driver hence we have to create docker-container driver
 	// NOTE: skip this auto-switch when Bake mode is active
@@ -319,7 +327,7 @@ func (p Plugin) Exec() error {
 			loadCmd.Stdin = bytes.NewReader(data)
 
 			// Attempt to load the tarball
-			if err := loadCmd.Run(); err != nil {
+			if err := runCommand(ctx, p.executor(), loadCmd); err != nil {
 				fmt.Printf("Error while loading buildkit image: %s\n", err)
 				loadedBuildkitTarball = false
 			}
@@ -342,7 +350,7 @@ func (p Plugin) Exec() error {
 				updateImageVersion(&p.Builder.DriverOptsNew, p.Builder.BuildkitVersion)
 			}
 			createCmd := cmdSetupBuildx(p.Builder, p.Builder.DriverOptsNew, p.BuildkitInheritAuth)
-			raw, err = createCmd.Output()
+			raw, err = runOutput(ctx, p.executor(), createCmd)
 			if err != nil {
 				fmt.Printf("Unable to setup buildx with new driver opts: %s\n", err)
 				// Mark that the fallback will be used
@@ -351,7 +359,7 @@ func (p Plugin) Exec() error {
 				p.Builder.Name = strings.TrimSuffix(string(raw), "\n")
 				// If builder creation is successful, inspect the builder
 				inspectCmd := cmdInspectBuildx(p.Builder.Name)
-				if err := inspectCmd.Run(); err != nil {
+				if err := runCommand(ctx, p.executor(), inspectCmd); err != nil {
 					fmt.Printf("Error while inspecting buildx builder with new driver opts: %s\n", err)
 					// Mark that the fallback will be used
 					shouldFallback = true
@@ -374,31 +382,32 @@ func (p Plugin) Exec() error {
 				updateImageVersion(&p.Builder.DriverOpts, version)
 			}
 			createCmd := cmdSetupBuildx(p.Builder, p.Builder.DriverOpts, p.BuildkitInheritAuth)
-			raw, err = createCmd.Output()
+			raw, err = runOutput(ctx, p.executor(), createCmd)
 			if err != nil {
-				return fmt.Errorf("error while creating buildx builder: %s and err: %s", string(raw), err)
+				return fmt.Errorf("error while creating buildx builder: %s and err: %s", redact(string(raw)), err)
 			}
 			p.Builder.Name = strings.TrimSuffix(string(raw), "\n")
 			inspectCmd := cmdInspectBuildx(p.Builder.Name)
-			if err := inspectCmd.Run(); err != nil {
+			if err := runCommand(ctx, p.executor(), inspectCmd); err != nil {
 				return fmt.Errorf("error while bootstraping buildx builder: %s", err)
 			}
 		}
 
 		removeCmd := cmdRemoveBuildx(p.Builder.Name)
 		defer func() {
-			removeCmd.Run()
+			// the builder must be removed even when the step was cancelled
+			cleanupCtx, cancel := cleanupContext(ctx, p.gracePeriod())
+			defer cancel()
+			if ctx.Err() != nil {
+				fmt.Printf("Removing buildx builder %s\n", p.Builder.Name)
+			}
+			runCommand(cleanupCtx, p.executor(), removeCmd)
 		}()
 	}
 
-	// Enforce mutual exclusivity: Bake mode and Push-only mode cannot be used together
-	if p.Build.BakeFile != "" && p.PushOnly {
-		return fmt.Errorf("conflict: Bake mode (PLUGIN_BAKE_FILE) and Push-only mode (PLUGIN_PUSH_ONLY) cannot be used together")
-	}
-
 	// Handle push-only mode if requested
 	if p.PushOnly {
-		return p.pushOnly()
+		return p.pushOnly(ctx)
 	}
 
 	var cmds []*exec.Cmd
@@ -408,14 +417,8 @@ func (p Plugin) Exec() error {
 
 	// Determine execution path: Bake mode vs Classic buildx build
 	if p.Build.BakeFile != "" {
-		// Inform about ignored classic cache settings
-		if len(p.Build.CacheFrom) > 0 || len(p.Build.CacheTo) > 0 || p.Build.NoCache {
-			fmt.Println("Bake mode: ignoring PLUGIN_CACHE_*; define cache in the bake file.")
-		}
-		// Tar export is not applied in Bake mode
-		if p.TarPath != "" {
-			fmt.Println("Bake mode: ignoring PLUGIN_TAR_PATH; define outputs in the bake file.")
-		}
+		// Classic cache settings and tar export are ignored in Bake mode,
+		// which is reported by Validate.
 		// Command to run buildx bake
 		cmds = append(cmds, commandBuildxBake(p.Build, p.Builder, p.Dryrun, p.MetadataFile))
 	} else {
@@ -425,13 +428,46 @@ func (p Plugin) Exec() error {
 		// Ensure tar output directory exists before buildx writes to it
 		if p.TarPath != "" && p.Dryrun && p.BuildxOutputFormat != "" {
 			dir := filepath.Dir(p.TarPath)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return fmt.Errorf("error: failed to create directory for tar file: %v", err)
+			// nothing is written to the filesystem while planning
+			if !p.planning() {
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					return fmt.Errorf("error: failed to create directory for tar file: %v", err)
+				}
 			}
 			fmt.Printf("Using direct buildx output (format: %s) to: %s\n", p.BuildxOutputFormat, p.TarPath)
 		}
 
-		cmds = append(cmds, commandBuildx(p.Build, p.Builder, p.Dryrun, p.MetadataFile, p.TarPath, p.BuildxOutputFormat)) // docker build
+		// Existing release tags must not be overwritten
+		targets := p.targets()
+		var existing []existingTag
+		if p.ImmutableTags != "" && !p.Dryrun {
+			var err error
+			if existing, err = p.existingTags(ctx, targets, false); err != nil {
+				return err
+			}
+		}
+		// the image is pushed by digest and only tagged once its digest is
+		// compared with the existing tags
+		var digest string
+		if len(existing) > 0 {
+			var err error
+			if digest, err = p.pushByDigest(ctx); err != nil {
+				return fmt.Errorf("unable to push the image by digest to check immutable tags: %s", err)
+			}
+			if targets, err = p.enforceImmutableTags(targets, existing, digest); err != nil {
+				return err
+			}
+		}
+		p.setTargets(targets)
+
+		switch {
+		case len(existing) == 0:
+			cmds = append(cmds, commandBuildx(p.Build, p.Builder, p.Dryrun, p.MetadataFile, p.TarPath, p.BuildxOutputFormat)) // docker build
+		case len(p.Build.Tags)+len(p.Build.ExtraTags) > 0:
+			cmds = append(cmds, commandTagDigest(p.Builder, fmt.Sprintf("%s@%s", p.Build.Repo, digest), targets)) // docker buildx imagetools create
+		default:
+			fmt.Println("All tags already exist, the image is only pushed by digest")
+		}
 	}
 
 	// execute all commands in batch mode.
@@ -455,7 +491,7 @@ func (p Plugin) Exec() error {
 
 				cmd.Stdout = teeWriter
 				cmd.Stderr = teeWriter
-				goroutineErr = cmd.Run()
+				goroutineErr = runCommand(ctx, p.executor(), cmd)
 			}()
 
 			// Run the parseCacheMetrics function and handle errors
@@ -473,30 +509,43 @@ func (p Plugin) Exec() error {
 				return goroutineErr
 			}
 		} else {
-			err = cmd.Run()
+			err = runCommand(ctx, p.executor(), cmd)
 		}
-		if err != nil && isCommandPrune(cmd.Args) {
-			fmt.Printf("Could not prune system containers. Ignoring...\n")
-		} else if err != nil && isCommandRmi(cmd.Args) {
-			fmt.Printf("Could not remove image %s. Ignoring...\n", cmd.Args[2])
-		} else if err != nil {
+		if err != nil {
 			return err
 		}
 	}
 
+	// verify the pushed tags in the registry
+	if p.VerifyPush && !p.Dryrun && p.Build.BakeFile == "" {
+		// without a metadata file the tags must agree with each other
+		digest, _ := getDigest(p.MetadataFile)
+		for _, target := range p.targets() {
+			if len(target.Tags) == 0 {
+				continue
+			}
+			if err := p.verifyPush(ctx, target.Repo, target.Tags, digest); err != nil {
+				return err
+			}
+		}
+	}
+
 	if p.Build.BakeFile == "" && p.TarPath != "" && p.Dryrun && p.BuildxOutputFormat == "" {
 		if len(p.Build.Tags) > 0 {
 			tag := p.Build.Tags[0]
 			fullImageName := fmt.Sprintf("%s:%s", p.Build.Repo, tag)
 
-			if !imageExists(fullImageName) {
+			if !p.imageExists(ctx, fullImageName) {
 				return fmt.Errorf("error: image %s not found in local daemon, cannot save to tar", fullImageName)
 			}
 
 			// Make sure the directory exists
 			dir := filepath.Dir(p.TarPath)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return fmt.Errorf("error: failed to create directory for tar file: %v", err)
+			// nothing is written to the filesystem while planning
+			if !p.planning() {
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					return fmt.Errorf("error: failed to create directory for tar file: %v", err)
+				}
 			}
 
 			// Save the image
@@ -506,7 +555,7 @@ func (p Plugin) Exec() error {
 			saveCmd.Stderr = os.Stderr
 			trace(saveCmd)
 
-			if err := saveCmd.Run(); err != nil {
+			if err := runCommand(ctx, p.executor(), saveCmd); err != nil {
 				return fmt.Errorf("error: failed to save image to tar: %v", err)
 			}
 
@@ -518,7 +567,7 @@ func (p Plugin) Exec() error {
 
 	// output the adaptive card (skipped in Bake mode)
 	if p.Build.BakeFile == "" && p.Builder.Driver == defaultDriver {
-		if err := p.writeCard(); err != nil {
+		if err := p.writeCard(ctx); err != nil {
 			fmt.Printf("Could not create adaptive card. %s\n", err)
 		}
 	} else if p.Build.BakeFile != "" {
@@ -529,31 +578,41 @@ func (p Plugin) Exec() error {
 	if p.ArtifactFile != "" {
 		// ArtifactRegistry here will be read from env variable ARTIFACT_REGISTRY (valid for ACR). If this env
 		// variable is not present, it'll be read from PLUGIN_REGISTRY which is valid for docker / ecr / gcr.
-		if digest, err := getDigest(p.MetadataFile); err == nil {
-			if err = drone.WritePluginArtifactFile(p.Daemon.RegistryType, p.ArtifactFile, p.Daemon.ArtifactRegistry, p.Build.Repo, digest, p.Build.Tags); err != nil {
-				fmt.Printf("Failed to write plugin artifact file at path: %s with error: %s\n", p.ArtifactFile, err)
+		targets := p.targets()
+		digests := make([]string, len(targets))
+		for i, target := range targets {
+			digest, err := p.imageDigest(ctx, target.Repo, target.Tags)
+			if err != nil {
+				fmt.Printf("Could not fetch the digest. %s\n", err)
+				continue
 			}
-		} else {
-			fmt.Printf("Could not fetch the digest. %s\n", err)
+			digests[i] = digest
+		}
+		if err := p.writeArtifactFile(targets, digests); err != nil {
+			fmt.Printf("Failed to write plugin artifact file at path: %s with error: %s\n", p.ArtifactFile, err)
 		}
 	}
 
-	// execute cleanup routines in batch mode
+	// execute cleanup routines
 	if p.Cleanup {
-		// clear the slice
-		cmds = nil
+		p.cleanup(ctx)
+	}
 
-		cmds = append(cmds, commandRmi(p.Build.Name)) // docker rmi
-		cmds = append(cmds, commandPrune())           // docker system prune -f
+	return nil
+}
 
-		for _, cmd := range cmds {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			trace(cmd)
+// imageDigest returns the digest of the built image in repo. Pushed images are looked
+// up in the registry, falling back to the digest in the metadata file.
+func (p Plugin) imageDigest(ctx context.Context, repo string, tags []string) (string, error) {
+	if !p.Dryrun && p.Build.BakeFile == "" && len(tags) > 0 {
+		image, err := p.inspectPushed(ctx, repo, tags)
+		if err == nil {
+			printPushed(image)
+			return image.Digest, nil
 		}
+		fmt.Printf("Warning: Could not look up the pushed image: %s\n", err)
 	}
-
-	return nil
+	return getDigest(p.MetadataFile)
 }
 
 func getDigest(metadataFile string) (string, error) {
@@ -588,41 +647,54 @@ func commandLogin(login Login) *exec.Cmd {
 	if loginCopy.Email != "" {
 		return commandLoginEmail(loginCopy)
 	}
-	return exec.Command(
+	cmd := exec.Command(
 		dockerExe, "login",
 		"-u", loginCopy.Username,
-		"-p", loginCopy.Password,
+		"--password-stdin",
 		loginCopy.Registry,
 	)
+	cmd.Stdin = strings.NewReader(loginCopy.Password)
+	return cmd
 }
 
-// helper function to set the credentials
-func setDockerAuth(username, password, registry, baseImageUsername,
-	baseImagePassword, baseImageRegistry string) ([]byte, error) {
-	var credentials []docker.RegistryCredentials
-	// add only docker registry to the config
-	dockerConfig := docker.NewConfig()
-	if password != "" {
-		pushToRegistryCreds := docker.RegistryCredentials{
-			Registry: registry,
-			Username: username,
-			Password: password,
-		}
-		// push registry auth
-		credentials = append(credentials, pushToRegistryCreds)
+// helper function to run a docker login command and report the outcome for
+// the registry, which is always the last argument of the command.
+func (p Plugin) login(ctx context.Context, kind string, cmd *exec.Cmd) error {
+	registry := registryName(cmd.Args[len(cmd.Args)-1])
+	raw, err := runCombinedOutput(ctx, p.executor(), cmd)
+	if err == nil && !strings.Contains(string(raw), "Login Succeeded") {
+		err = fmt.Errorf("docker login did not report success")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to log in to %s %s: %s", kind, registry, loginFailureReason(raw, err))
+	}
+	fmt.Printf("Logged in to %s %s\n", kind, registry)
+	return nil
+}
+
+// helper function that returns a printable name for a login registry.
+func registryName(registry string) string {
+	if registry == "" {
+		return v1RegistryURL
 	}
+	return registry
+}
 
-	if baseImageRegistry != "" {
-		pullFromRegistryCreds := docker.RegistryCredentials{
-			Registry: baseImageRegistry,
-			Username: baseImageUsername,
-			Password: baseImagePassword,
+// helper function that extracts the reason for a failed login from the
+// docker output, falling back to the command error.
+func loginFailureReason(output []byte, err error) string {
+	var reason string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "WARNING!") {
+			continue
 		}
-		// base image registry auth
-		credentials = append(credentials, pullFromRegistryCreds)
+		reason = strings.TrimPrefix(line, "Error response from daemon: ")
 	}
-	// Creates docker config for both the registries used for authentication
-	return dockerConfig.CreateDockerConfigJson(credentials)
+	if reason == "" {
+		return redact(err.Error())
+	}
+	return redact(reason)
 }
 
 // helper to login via access token
@@ -647,13 +719,15 @@ func commandPull(repo string) *exec.Cmd {
 }
 
 func commandLoginEmail(login Login) *exec.Cmd {
-	return exec.Command(
+	cmd := exec.Command(
 		dockerExe, "login",
 		"-u", login.Username,
-		"-p", login.Password,
+		"--password-stdin",
 		"-e", login.Email,
 		login.Registry,
 	)
+	cmd.Stdin = strings.NewReader(login.Password)
+	return cmd
 }
 
 // helper function to create the docker info command.
@@ -683,6 +757,9 @@ func commandBuildx(build Build, builder Builder, dryrun bool, metadataFile strin
 	for _, t := range build.Tags {
 		args = append(args, "-t", fmt.Sprintf("%s:%s", build.Repo, t))
 	}
+	for _, t := range build.ExtraTags {
+		args = append(args, "-t", t)
+	}
 	if dryrun {
 		if tarPath != "" && outputFormat != "" {
 			args = append(args, fmt.Sprintf("--output=type=%s,dest=%s", outputFormat, tarPath))
@@ -1067,15 +1144,6 @@ func isCommandBuildxBuild(args []string) bool {
 	return len(args) > 3 && args[1] == "buildx" && args[2] == "build"
 }
 
-// helper to check if args match "docker prune"
-func isCommandPrune(args []string) bool {
-	return len(args) > 3 && args[2] == "prune"
-}
-
-func commandPrune() *exec.Cmd {
-	return exec.Command(dockerExe, "system", "prune", "-f")
-}
-
 // helper to check if args match "docker rmi"
 func isCommandRmi(args []string) bool {
 	return len(args) > 2 && args[1] == "rmi"
@@ -1097,18 +1165,36 @@ func commandSaveTar(tag string, tarPath string) *exec.Cmd {
 	return exec.Command(dockerExe, "save", "-o", tarPath, tag)
 }
 
-func imageExists(tag string) bool {
+func (p Plugin) imageExists(ctx context.Context, tag string) bool {
 	cmd := exec.Command(dockerExe, "image", "inspect", tag)
-	return cmd.Run() == nil
+	return runCommand(ctx, p.executor(), cmd) == nil
 }
 
-func getDigestAfterPush(tag string) (string, error) {
-	cmd := exec.Command(dockerExe, "inspect", "--format", "{{ index (split (index .RepoDigests 0) \"@\") 1 }}", tag)
-	output, err := cmd.Output()
+// localRepoDigest returns the digest docker recorded for repo when the local
+// image was pushed, from the RepoDigests of the image.
+func (p Plugin) localRepoDigest(ctx context.Context, repo, tag string) (string, error) {
+	image := fmt.Sprintf("%s:%s", repo, tag)
+	cmd := exec.Command(dockerExe, "image", "inspect", "--format", "{{json .RepoDigests}}", image)
+	out, err := runOutput(ctx, p.executor(), cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect %s: %w", image, err)
+	}
+	var repoDigests []string
+	if err := json.Unmarshal(out, &repoDigests); err != nil {
+		return "", fmt.Errorf("failed to parse the repo digests of %s: %w", image, err)
+	}
+	want, err := parseImageRef(image)
 	if err != nil {
-		return "", fmt.Errorf("failed to get digest for %s: %w", tag, err)
+		return "", err
 	}
-	return strings.TrimSpace(string(output)), nil
+	// docker records the familiar name, e.g. octocat/app for docker.io/octocat/app
+	for _, repoDigest := range repoDigests {
+		ref, err := parseImageRef(repoDigest)
+		if err == nil && ref.Registry == want.Registry && ref.Repository == want.Repository {
+			return ref.Reference, nil
+		}
+	}
+	return "", fmt.Errorf("no repo digest of %s found for %s", repo, image)
 }
 
 func writeSSHPrivateKey(key string) (path string, err error) {
@@ -1128,10 +1214,27 @@ func writeSSHPrivateKey(key string) (path string, err error) {
 	return path, nil
 }
 
+// executor returns the Executor used to run docker commands, falling back to
+// running them directly on the host.
+func (p Plugin) executor() Executor {
+	if p.Executor != nil {
+		return p.Executor
+	}
+	return execExecutor{gracePeriod: p.gracePeriod()}
+}
+
+// gracePeriod returns the time given to cancelled commands to exit.
+func (p Plugin) gracePeriod() time.Duration {
+	if p.GracePeriod > 0 {
+		return p.GracePeriod
+	}
+	return defaultGracePeriod
+}
+
 // trace writes each command to stdout with the command wrapped in an xml
 // tag so that it can be extracted and displayed in the logs.
 func trace(cmd *exec.Cmd) {
-	fmt.Fprintf(os.Stdout, "+ %s\n", strings.Join(cmd.Args, " "))
+	fmt.Fprintf(os.Stdout, "+ %s\n", redact(strings.Join(cmd.Args, " ")))
 }
 
 // Helper function to update image version in driver options
@@ -1144,7 +1247,7 @@ func updateImageVersion(driverOpts *[]string, version string) {
 }
 
 // pushOnly handles pushing images without building them
-func (p Plugin) pushOnly() error {
+func (p Plugin) pushOnly(ctx context.Context) error {
 	// If source tar path is provided, load the image first
 	if p.SourceTarPath != "" {
 		fileInfo, err := os.Stat(p.SourceTarPath)
@@ -1164,7 +1267,7 @@ func (p Plugin) pushOnly() error {
 		loadCmd.Stdout = os.Stdout
 		loadCmd.Stderr = os.Stderr
 		trace(loadCmd)
-		if err := loadCmd.Run(); err != nil {
+		if err := runCommand(ctx, p.executor(), loadCmd); err != nil {
 			return fmt.Errorf("failed to load image from tar: %w", err)
 		}
 	}
@@ -1206,14 +1309,13 @@ func (p Plugin) pushOnly() error {
 	}
 
 	// For each source tag and target tag combination
-	var digest string
 	taggedForPush := make(map[string]bool)
 
 	for _, sourceTag := range sourceTags {
 		sourceFullImageName := fmt.Sprintf("%s:%s", sourceImageName, sourceTag)
 
 		// Check if the source image exists in local daemon
-		if !imageExists(sourceFullImageName) {
+		if !p.imageExists(ctx, sourceFullImageName) {
 			fmt.Printf("Warning: Source image %s not found\n", sourceFullImageName)
 			// Continue to the next source tag if available, otherwise return error
 			if len(sourceTags) > 1 {
@@ -1223,79 +1325,126 @@ func (p Plugin) pushOnly() error {
 		}
 
 		// For each target tag, tag and push
-		for _, targetTag := range p.Build.Tags {
-			targetFullImageName := fmt.Sprintf("%s:%s", p.Build.Repo, targetTag)
+		for _, target := range p.targets() {
+			for _, targetTag := range target.Tags {
+				targetFullImageName := fmt.Sprintf("%s:%s", target.Repo, targetTag)
 
-			// Skip if source and target are identical
-			if sourceFullImageName == targetFullImageName {
-				fmt.Printf("Source and target image names are identical: %s\n", sourceFullImageName)
-			} else {
-				// Tag the source image with the target name
-				fmt.Printf("Tagging %s as %s\n", sourceFullImageName, targetFullImageName)
-				tagCmd := exec.Command(dockerExe, "tag", sourceFullImageName, targetFullImageName)
-				tagCmd.Stdout = os.Stdout
-				tagCmd.Stderr = os.Stderr
-				trace(tagCmd)
-				if err := tagCmd.Run(); err != nil {
-					return fmt.Errorf("failed to tag image %s as %s: %w", sourceFullImageName, targetFullImageName, err)
+				// Skip if source and target are identical
+				if sourceFullImageName == targetFullImageName {
+					fmt.Printf("Source and target image names are identical: %s\n", sourceFullImageName)
+				} else {
+					// Tag the source image with the target name
+					fmt.Printf("Tagging %s as %s\n", sourceFullImageName, targetFullImageName)
+					tagCmd := exec.Command(dockerExe, "tag", sourceFullImageName, targetFullImageName)
+					tagCmd.Stdout = os.Stdout
+					tagCmd.Stderr = os.Stderr
+					trace(tagCmd)
+					if err := runCommand(ctx, p.executor(), tagCmd); err != nil {
+						return fmt.Errorf("failed to tag image %s as %s: %w", sourceFullImageName, targetFullImageName, err)
+					}
 				}
+
+				taggedForPush[targetFullImageName] = true
 			}
+		}
+	}
+
+	targets := p.targets()
 
-			taggedForPush[targetFullImageName] = true
+	// Existing release tags must not be overwritten. The local image is
+	// compared by its ID with the config digest of the existing tags.
+	if p.ImmutableTags != "" {
+		existing, err := p.existingTags(ctx, targets, true)
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			// the targets are tagged from the same local image
+			image := fmt.Sprintf("%s:%s", existing[0].Repo, existing[0].Tag)
+			digest, err := p.imageID(ctx, image)
+			if err != nil {
+				return fmt.Errorf("unable to inspect %s: %s", image, err)
+			}
+			if targets, err = p.enforceImmutableTags(targets, existing, digest); err != nil {
+				return err
+			}
+			p.setTargets(targets)
 		}
 	}
 
 	// Push all successfully tagged images
-	for _, tag := range p.Build.Tags {
-		fullImageName := fmt.Sprintf("%s:%s", p.Build.Repo, tag)
+	for _, target := range targets {
+		for _, tag := range target.Tags {
+			fullImageName := fmt.Sprintf("%s:%s", target.Repo, tag)
 
-		// Check if image exists in local daemon
-		if !imageExists(fullImageName) {
-			return fmt.Errorf("image %s not found, cannot push", fullImageName)
-		}
+			// Check if image exists in local daemon
+			if !p.imageExists(ctx, fullImageName) {
+				return fmt.Errorf("image %s not found, cannot push", fullImageName)
+			}
 
-		// Push image
-		fmt.Println("Pushing image:", fullImageName)
-		pushCmd := commandPush(p.Build, tag)
-		pushCmd.Stdout = os.Stdout
-		pushCmd.Stderr = os.Stderr
-		trace(pushCmd)
-		if err := pushCmd.Run(); err != nil {
-			return fmt.Errorf("failed to push image %s: %w", fullImageName, err)
+			// Push image
+			fmt.Println("Pushing image:", fullImageName)
+			pushCmd := commandPush(Build{Repo: target.Repo}, tag)
+			pushCmd.Stdout = os.Stdout
+			pushCmd.Stderr = os.Stderr
+			trace(pushCmd)
+			if err := runCommand(ctx, p.executor(), pushCmd); err != nil {
+				return fmt.Errorf("failed to push image %s: %w", fullImageName, err)
+			}
 		}
+	}
 
-		// Get the digest after push (we only need one)
-		if digest == "" {
-			d, err := getDigestAfterPush(fullImageName)
-			if err == nil {
-				digest = d
-			} else {
-				fmt.Printf("Warning: Could not get digest for %s: %v\n", fullImageName, err)
+	// Verify the pushed tags in the registry
+	if p.VerifyPush {
+		for _, target := range targets {
+			if len(target.Tags) == 0 {
+				continue
+			}
+			if err := p.verifyPush(ctx, target.Repo, target.Tags, ""); err != nil {
+				return err
 			}
 		}
 	}
 
 	// Output the adaptive card
 	if p.Builder.Driver == defaultDriver {
-		if err := p.writeCard(); err != nil {
+		if err := p.writeCard(ctx); err != nil {
 			fmt.Printf("Could not create adaptive card. %s\n", err)
 		}
 	}
 
-	// Write to artifact file
-	if p.ArtifactFile != "" && digest != "" {
-		if err := drone.WritePluginArtifactFile(
-			p.Daemon.RegistryType,
-			p.ArtifactFile,
-			p.Daemon.ArtifactRegistry,
-			p.Build.Repo,
-			digest,
-			p.Build.Tags,
-		); err != nil {
+	// Look up the pushed digests in the registry and write the artifact file
+	if p.ArtifactFile != "" {
+		digests := make([]string, len(targets))
+		for i, target := range targets {
+			if len(target.Tags) == 0 {
+				continue
+			}
+			image, err := p.inspectPushed(ctx, target.Repo, target.Tags)
+			if err == nil {
+				printPushed(image)
+				digests[i] = image.Digest
+				continue
+			}
+			// fall back to the digest docker recorded when pushing
+			digest, localErr := p.localRepoDigest(ctx, target.Repo, target.Tags[0])
+			if localErr != nil {
+				fmt.Printf("Warning: Could not get digest for %s: %v, %v\n", target.Repo, err, localErr)
+				continue
+			}
+			fmt.Printf("Warning: Could not look up %s in the registry, using the local digest: %v\n", target.Repo, err)
+			digests[i] = digest
+		}
+		if err := p.writeArtifactFile(targets, digests); err != nil {
 			fmt.Printf("Failed to write plugin artifact file at path: %s with error: %s\n",
 				p.ArtifactFile, err)
 		}
 	}
 
+	// Execute cleanup routines
+	if p.Cleanup {
+		p.cleanup(ctx)
+	}
+
 	return nil
 }
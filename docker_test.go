@@ -1,6 +1,8 @@
 package docker
 
 import (
+	"context"
+	"io"
 	"os"
 	"os/exec"
 	"reflect"
@@ -920,3 +922,63 @@ func TestGetProxyValue(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandLogin(t *testing.T) {
+	tests := []struct {
+		name  string
+		login Login
+		want  []string
+	}{
+		{
+			name:  "password",
+			login: Login{Registry: "registry.example.com", Username: "octocat", Password: "hunter2"},
+			want:  []string{dockerExe, "login", "-u", "octocat", "--password-stdin", "registry.example.com"},
+		},
+		{
+			name:  "email",
+			login: Login{Registry: "registry.example.com", Username: "octocat", Password: "hunter2", Email: "octocat@github.com"},
+			want:  []string{dockerExe, "login", "-u", "octocat", "--password-stdin", "-e", "octocat@github.com", "registry.example.com"},
+		},
+		{
+			name:  "dockerhub v2",
+			login: Login{Registry: v2HubRegistryURL, Username: "octocat", Password: "hunter2"},
+			want:  []string{dockerExe, "login", "-u", "octocat", "--password-stdin", v1RegistryURL},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := commandLogin(tt.login)
+			if !reflect.DeepEqual(cmd.Args, tt.want) {
+				t.Errorf("commandLogin() = %v, want %v", cmd.Args, tt.want)
+			}
+			stdin, _ := io.ReadAll(cmd.Stdin)
+			if string(stdin) != tt.login.Password {
+				t.Errorf("Got stdin %q, want password", stdin)
+			}
+		})
+	}
+}
+
+func TestLoginReporting(t *testing.T) {
+	transcript := strings.Join([]string{
+		`{"args":["docker","login","-u","octocat","--password-stdin","registry.example.com"],"stdout":"Login Succeeded\n","exit_code":0}`,
+		`{"args":["docker","login","-u","octocat","--password-stdin","registry.example.com"],"stderr":"Error response from daemon: Get \"https://registry.example.com/v2/\": unauthorized: incorrect username or password\n","exit_code":1}`,
+	}, "\n")
+
+	e, err := NewReplayExecutor(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatalf("unable to read transcript: %s", err)
+	}
+	p := Plugin{Executor: e}
+	login := Login{Registry: "registry.example.com", Username: "octocat", Password: "hunter2"}
+
+	if err := p.login(context.Background(), "registry", commandLogin(login)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	err = p.login(context.Background(), "base image registry", commandLogin(login))
+	want := `failed to log in to base image registry registry.example.com: Get "https://registry.example.com/v2/": unauthorized: incorrect username or password`
+	if err == nil || err.Error() != want {
+		t.Errorf("Got error %v, want %s", err, want)
+	}
+}
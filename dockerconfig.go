@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/drone-plugins/drone-buildx/config/docker"
+)
+
+// needsDockerConfig reports whether the plugin has any credentials or
+// settings for the generated config.json.
+func (p Plugin) needsDockerConfig() bool {
+	return p.Login.Config != "" || len(p.Login.CredHelpers) > 0 || p.Login.CredsStore != "" || len(p.registryAuths()) > 0
+}
+
+// dockerConfig returns the content of the generated config.json. The sources
+// are merged in order of increasing precedence, so for a registry listed more
+// than once the last source wins:
+//
+//  1. the user config from PLUGIN_CONFIG, whose other keys are kept as is
+//  2. the base image and pull registry credentials
+//  3. the destination credentials
+//  4. the push registry credentials or access token
+//
+// Credential helpers and the credential store are added last.
+func (p Plugin) dockerConfig() ([]byte, error) {
+	content, err := p.userDockerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	config := docker.NewConfig()
+	if err := config.AddCredentials(p.registryAuths()); err != nil {
+		return nil, err
+	}
+	for registry, helper := range p.Login.CredHelpers {
+		config.SetCredHelper(registry, credHelperName(helper))
+	}
+	if p.Login.CredsStore != "" {
+		config.SetCredsStore(credHelperName(p.Login.CredsStore))
+	}
+	return config.MergeConfigJson(content)
+}
+
+// registryAuths returns the registry credentials of the plugin in order of
+// increasing precedence.
+func (p Plugin) registryAuths() []docker.RegistryCredentials {
+	var creds []docker.RegistryCredentials
+	for _, r := range p.pullRegistries() {
+		if r.IdentityToken != "" || (r.Username != "" && r.Password != "") {
+			creds = append(creds, docker.RegistryCredentials{
+				Registry:      configRegistry(r.Registry),
+				Username:      r.Username,
+				Password:      r.Password,
+				IdentityToken: r.IdentityToken,
+			})
+		}
+	}
+	for _, d := range p.Destinations {
+		if d.IdentityToken != "" || d.Password != "" {
+			creds = append(creds, docker.RegistryCredentials{
+				Registry:      configRegistry(d.registry()),
+				Username:      d.Username,
+				Password:      d.Password,
+				IdentityToken: d.IdentityToken,
+			})
+		}
+	}
+	switch {
+	case p.Login.Password != "":
+		creds = append(creds, docker.RegistryCredentials{
+			Registry: configRegistry(p.Login.Registry),
+			Username: p.Login.Username,
+			Password: p.Login.Password,
+		})
+	case p.Login.AccessToken != "":
+		creds = append(creds, docker.RegistryCredentials{
+			Registry: configRegistry(p.Login.Registry),
+			Username: "oauth2accesstoken",
+			Password: p.Login.AccessToken,
+		})
+	}
+	return creds
+}
+
+// helper function that returns the registries with auths in the content of
+// a config.json.
+func configuredRegistries(content []byte) map[string]bool {
+	var config struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}
+	json.Unmarshal(content, &config)
+	configured := map[string]bool{}
+	for registry := range config.Auths {
+		configured[normalizeRegistry(registry)] = true
+	}
+	return configured
+}
+
+// helper function that returns the config.json key of a registry, which is
+// the v1 index URL for Docker Hub like docker login writes it.
+func configRegistry(registry string) string {
+	if normalizeRegistry(registry) == dockerHubRegistry {
+		return v1RegistryURL
+	}
+	return registry
+}
+
+// helper function that returns the user provided config.json from
+// PLUGIN_CONFIG, given either as JSON content or as a file path.
+func (p Plugin) userDockerConfig() ([]byte, error) {
+	if p.Login.Config == "" {
+		return nil, nil
+	}
+	// Try to parse as JSON first to determine if it's content or file path
+	var jsonTest interface{}
+	if json.Unmarshal([]byte(p.Login.Config), &jsonTest) == nil {
+		// Valid JSON, treat as content
+		return []byte(p.Login.Config), nil
+	}
+	if _, err := os.Stat(p.Login.Config); err != nil {
+		// Neither valid JSON nor existing file
+		return nil, fmt.Errorf("Docker config must be either valid JSON content or a path to an existing config file")
+	}
+	// Not JSON but file exists, read it
+	data, err := os.ReadFile(p.Login.Config)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading docker config file '%s': %s", p.Login.Config, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/drone-plugins/drone-buildx/config/docker"
+)
+
+func TestDockerConfigPrecedence(t *testing.T) {
+	p := Plugin{
+		Login: Login{
+			Registry: "registry.example.com",
+			Username: "push",
+			Password: "push-password",
+			Config: `{"auths": {
+				"registry.example.com": {"auth": "dXNlcjp1c2Vy"},
+				"myregistry.azurecr.io": {"identitytoken": "refresh"}
+			}}`,
+		},
+		BaseImageRegistry: "registry.example.com",
+		BaseImageUsername: "pull",
+		BaseImagePassword: "pull-password",
+		PullRegistries: []PullRegistry{
+			{Registry: "ghcr.io", Username: "octocat", Password: "ghcr-password"},
+			{Registry: "quay.io", IdentityToken: "quay-refresh"},
+		},
+		Destinations: []Destination{
+			{Repo: "octocat/app", Username: "octocat", Password: "hub-password"},
+			{Repo: "other.azurecr.io/app", Username: "0000", IdentityToken: "acr-refresh"},
+		},
+	}
+	if !p.needsDockerConfig() {
+		t.Fatal("expected a docker config")
+	}
+	data, err := p.dockerConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var config docker.Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatalf("invalid config: %s", err)
+	}
+	want := map[string]docker.Auth{
+		// push credentials win over the base image and user credentials
+		"registry.example.com":        {Auth: "cHVzaDpwdXNoLXBhc3N3b3Jk"},
+		"myregistry.azurecr.io":       {IdentityToken: "refresh"},
+		"ghcr.io":                     {Auth: "b2N0b2NhdDpnaGNyLXBhc3N3b3Jk"},
+		"quay.io":                     {IdentityToken: "quay-refresh"},
+		"other.azurecr.io":            {Auth: "MDAwMDo=", IdentityToken: "acr-refresh"},
+		"https://index.docker.io/v1/": {Auth: "b2N0b2NhdDpodWItcGFzc3dvcmQ="},
+	}
+	if !reflect.DeepEqual(config.Auths, want) {
+		t.Errorf("got auths %+v, want %+v", config.Auths, want)
+	}
+}
+
+func TestDockerConfigAccessToken(t *testing.T) {
+	p := Plugin{Login: Login{Registry: "gcr.io", AccessToken: "ya29.token"}}
+	auths := p.registryAuths()
+	want := []docker.RegistryCredentials{{Registry: "gcr.io", Username: "oauth2accesstoken", Password: "ya29.token"}}
+	if !reflect.DeepEqual(auths, want) {
+		t.Errorf("got %+v, want %+v", auths, want)
+	}
+
+	if (Plugin{}).needsDockerConfig() {
+		t.Error("guest mode should not write a docker config")
+	}
+}
+
+func TestConfiguredRegistriesSkipLogin(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	e := &metadataExecutor{}
+	p := Plugin{
+		Login:          Login{Registry: "registry.example.com", Username: "push", Password: "push-password", CredsStore: "desktop"},
+		PullRegistries: []PullRegistry{{Registry: "ghcr.io", Username: "octocat", Password: "ghcr-password"}},
+		Destinations:   []Destination{{Repo: "octocat/app", Username: "octocat", Password: "hub-password"}},
+		Build:          Build{Repo: "registry.example.com/app", Tags: []string{"latest"}, Context: "."},
+		Daemon:         Daemon{Disabled: true},
+		Executor:       e,
+	}
+	if err := p.Exec(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, args := range e.args {
+		if isCommandLogin(args) {
+			t.Errorf("unexpected login %q for a registry configured in config.json", args)
+		}
+	}
+
+	configured := configuredRegistries([]byte(`{"auths": {"https://index.docker.io/v1/": {}, "ghcr.io": {}}}`))
+	if want := map[string]bool{dockerHubRegistry: true, "ghcr.io": true}; !reflect.DeepEqual(configured, want) {
+		t.Errorf("got configured registries %v, want %v", configured, want)
+	}
+}
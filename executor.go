@@ -0,0 +1,287 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Executor runs the commands issued by the plugin. Implementations must
+	// honour the Stdin, Stdout and Stderr already attached to the command and
+	// stop the command when ctx is cancelled.
+	Executor interface {
+		Run(ctx context.Context, cmd *exec.Cmd) (Result, error)
+	}
+
+	// Result describes a single command invocation.
+	Result struct {
+		Args     []string      `json:"args"`             // Command line that was executed
+		Stdout   string        `json:"stdout,omitempty"` // Standard output written by the command
+		Stderr   string        `json:"stderr,omitempty"` // Standard error written by the command
+		ExitCode int           `json:"exit_code"`        // Exit code, -1 if the command could not be started
+		Error    string        `json:"error,omitempty"`  // Error reported when running the command
+		Started  time.Time     `json:"started"`          // Time the command was started
+		Duration time.Duration `json:"duration"`         // Wall clock time spent running the command
+	}
+
+	// execExecutor runs commands on the host using os/exec. On cancellation
+	// the process is asked to terminate and killed once the grace period
+	// has elapsed.
+	execExecutor struct {
+		gracePeriod time.Duration
+	}
+
+	// RecordingExecutor wraps another Executor and writes a JSON line
+	// transcript of every invocation and its exit status.
+	RecordingExecutor struct {
+		next Executor
+		mu   sync.Mutex
+		w    io.Writer
+	}
+
+	// ReplayExecutor plays back a transcript written by a RecordingExecutor
+	// without running anything. Commands must be issued in the recorded order.
+	ReplayExecutor struct {
+		mu      sync.Mutex
+		entries []Result
+		pos     int
+	}
+
+	// exitStatusError is returned by the ReplayExecutor for recorded
+	// invocations that exited with a non-zero status.
+	exitStatusError int
+
+	// syncBuffer is a bytes.Buffer safe for concurrent writes, used when
+	// stdout and stderr are captured from separate goroutines. With a limit,
+	// only the last limit bytes are kept.
+	syncBuffer struct {
+		mu    sync.Mutex
+		buf   bytes.Buffer
+		limit int
+	}
+)
+
+// maxTeeCapture is the output kept of a stream that is also written to the
+// command's own writer, e.g. the build log. Callers that need the full output
+// leave the writer unset. Transcripts always record the full output.
+const maxTeeCapture = 64 * 1024
+
+var defaultExecutor Executor = execExecutor{gracePeriod: defaultGracePeriod}
+
+// Run runs cmd to completion, capturing its output in addition to any
+// writers already attached to it.
+func (e execExecutor) Run(ctx context.Context, cmd *exec.Cmd) (Result, error) {
+	var stdout, stderr syncBuffer
+	cmd.Stdout = captureWriter(cmd.Stdout, &stdout)
+	cmd.Stderr = captureWriter(cmd.Stderr, &stderr)
+
+	res := Result{Args: cmd.Args, Started: time.Now()}
+	err := context.Cause(ctx)
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				stopProcess(cmd.Process, done, e.gracePeriod)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+		if err != nil && ctx.Err() != nil {
+			err = fmt.Errorf("%s: %w", err, context.Cause(ctx))
+		}
+	}
+	res.Duration = time.Since(res.Started)
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+	res.ExitCode = exitCode(cmd, err)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res, err
+}
+
+// NewRecordingExecutor returns an Executor that runs commands with next and
+// writes the transcript to w.
+func NewRecordingExecutor(next Executor, w io.Writer) *RecordingExecutor {
+	if next == nil {
+		next = defaultExecutor
+	}
+	return &RecordingExecutor{next: next, w: w}
+}
+
+// Run runs cmd and appends the result to the transcript. Streamed output is
+// recorded in full, so that parsers of the output work on replay.
+func (e *RecordingExecutor) Run(ctx context.Context, cmd *exec.Cmd) (Result, error) {
+	var stdout, stderr *syncBuffer
+	if cmd.Stdout != nil {
+		stdout = &syncBuffer{}
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, stdout)
+	}
+	if cmd.Stderr != nil {
+		stderr = &syncBuffer{}
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, stderr)
+	}
+	res, err := e.next.Run(ctx, cmd)
+
+	// the transcript is written to disk, secrets must not end up in it
+	entry := res
+	if stdout != nil {
+		entry.Stdout = stdout.String()
+	}
+	if stderr != nil {
+		entry.Stderr = stderr.String()
+	}
+	entry.Args = redactArgs(res.Args)
+	entry.Stdout = redact(entry.Stdout)
+	entry.Stderr = redact(entry.Stderr)
+	entry.Error = redact(res.Error)
+
+	data, merr := json.Marshal(entry)
+	if merr != nil {
+		return res, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, werr := fmt.Fprintf(e.w, "%s\n", data); werr != nil && err == nil {
+		err = fmt.Errorf("failed to write command transcript: %w", werr)
+	}
+	return res, err
+}
+
+// NewReplayExecutor reads a transcript written by a RecordingExecutor.
+func NewReplayExecutor(r io.Reader) (*ReplayExecutor, error) {
+	e := &ReplayExecutor{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var res Result
+		if err := json.Unmarshal(line, &res); err != nil {
+			return nil, fmt.Errorf("invalid transcript entry %d: %w", len(e.entries)+1, err)
+		}
+		e.entries = append(e.entries, res)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+	return e, nil
+}
+
+// Run returns the next recorded result after checking that cmd matches the
+// recorded command line. Recorded output is written to the command writers.
+// Transcripts are redacted, so the command line is compared in redacted form.
+func (e *ReplayExecutor) Run(ctx context.Context, cmd *exec.Cmd) (Result, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return Result{Args: cmd.Args, ExitCode: -1}, context.Cause(ctx)
+	}
+	if e.pos >= len(e.entries) {
+		return Result{Args: cmd.Args, ExitCode: -1}, fmt.Errorf("unexpected command, transcript exhausted: %s", redact(strings.Join(cmd.Args, " ")))
+	}
+	res := e.entries[e.pos]
+	if args := redactArgs(cmd.Args); !reflect.DeepEqual(res.Args, args) {
+		return Result{Args: cmd.Args, ExitCode: -1}, fmt.Errorf("command %d does not match transcript: got %q, want %q", e.pos+1, strings.Join(args, " "), strings.Join(res.Args, " "))
+	}
+	e.pos++
+
+	if cmd.Stdout != nil {
+		io.WriteString(cmd.Stdout, res.Stdout)
+	}
+	if cmd.Stderr != nil {
+		io.WriteString(cmd.Stderr, res.Stderr)
+	}
+	switch {
+	case res.ExitCode == -1 && res.Error != "":
+		return res, errors.New(res.Error)
+	case res.ExitCode != 0:
+		return res, exitStatusError(res.ExitCode)
+	}
+	return res, nil
+}
+
+// Remaining returns the recorded invocations that have not been replayed.
+func (e *ReplayExecutor) Remaining() []Result {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.entries[e.pos:]
+}
+
+func (e exitStatusError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, err := b.buf.Write(p)
+	if b.limit > 0 && b.buf.Len() > b.limit {
+		b.buf.Next(b.buf.Len() - b.limit)
+	}
+	return n, err
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// helper function that duplicates writes to w into capture. When w is set,
+// only the tail of the output is captured.
+func captureWriter(w io.Writer, capture *syncBuffer) io.Writer {
+	if w == nil {
+		return capture
+	}
+	capture.limit = maxTeeCapture
+	return io.MultiWriter(w, capture)
+}
+
+// helper function that returns the exit code for a finished command.
+func exitCode(cmd *exec.Cmd, err error) int {
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+// helper function to run a command and discard its output.
+func runCommand(ctx context.Context, e Executor, cmd *exec.Cmd) error {
+	_, err := e.Run(ctx, cmd)
+	return err
+}
+
+// helper function to run a command and return its standard output.
+func runOutput(ctx context.Context, e Executor, cmd *exec.Cmd) ([]byte, error) {
+	res, err := e.Run(ctx, cmd)
+	return []byte(res.Stdout), err
+}
+
+// helper function to run a command and return its standard output and
+// standard error.
+func runCombinedOutput(ctx context.Context, e Executor, cmd *exec.Cmd) ([]byte, error) {
+	res, err := e.Run(ctx, cmd)
+	return []byte(res.Stdout + res.Stderr), err
+}
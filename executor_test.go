@@ -0,0 +1,259 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordingExecutor(t *testing.T) {
+	var transcript bytes.Buffer
+	e := NewRecordingExecutor(nil, &transcript)
+
+	out, err := runOutput(context.Background(), e, exec.Command("sh", "-c", "echo hello; echo oops >&2"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(out) != "hello\n" {
+		t.Errorf("Got output %q, want %q", out, "hello\n")
+	}
+
+	res, err := e.Run(context.Background(), exec.Command("sh", "-c", "exit 3"))
+	if err == nil {
+		t.Fatal("expected error for non-zero exit status")
+	}
+	if res.ExitCode != 3 {
+		t.Errorf("Got exit code %d, want 3", res.ExitCode)
+	}
+
+	replay, err := NewReplayExecutor(&transcript)
+	if err != nil {
+		t.Fatalf("unable to read transcript: %s", err)
+	}
+	if got := len(replay.Remaining()); got != 2 {
+		t.Fatalf("Got %d transcript entries, want 2", got)
+	}
+	first := replay.Remaining()[0]
+	if first.Stdout != "hello\n" || first.Stderr != "oops\n" || first.ExitCode != 0 {
+		t.Errorf("Unexpected transcript entry %+v", first)
+	}
+}
+
+func TestReplayExecutor(t *testing.T) {
+	transcript := strings.Join([]string{
+		`{"args":["docker","buildx","create"],"stdout":"builder-1\n","exit_code":0}`,
+		`{"args":["docker","push","repo:latest"],"stderr":"denied\n","exit_code":1}`,
+	}, "\n")
+
+	e, err := NewReplayExecutor(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatalf("unable to read transcript: %s", err)
+	}
+
+	out, err := runOutput(context.Background(), e, exec.Command("docker", "buildx", "create"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(out) != "builder-1\n" {
+		t.Errorf("Got output %q, want %q", out, "builder-1\n")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("docker", "push", "repo:latest")
+	cmd.Stderr = &stderr
+	if err := runCommand(context.Background(), e, cmd); err == nil || err.Error() != "exit status 1" {
+		t.Errorf("Got error %v, want exit status 1", err)
+	}
+	if stderr.String() != "denied\n" {
+		t.Errorf("Got stderr %q, want %q", stderr.String(), "denied\n")
+	}
+
+	if err := runCommand(context.Background(), e, exec.Command("docker", "info")); err == nil {
+		t.Error("expected error once the transcript is exhausted")
+	}
+}
+
+func TestReplayExecutorMismatch(t *testing.T) {
+	e, err := NewReplayExecutor(strings.NewReader(`{"args":["docker","info"],"exit_code":0}`))
+	if err != nil {
+		t.Fatalf("unable to read transcript: %s", err)
+	}
+	if err := runCommand(context.Background(), e, exec.Command("docker", "version")); err == nil {
+		t.Error("expected error for command that does not match the transcript")
+	}
+	if got := len(e.Remaining()); got != 1 {
+		t.Errorf("Got %d remaining entries, want 1", got)
+	}
+}
+
+func TestPushOnlyCommandPlan(t *testing.T) {
+	transcript := strings.Join([]string{
+		`{"args":["docker","image","inspect","source:1.0"],"exit_code":0}`,
+		`{"args":["docker","tag","source:1.0","octocat/app:latest"],"exit_code":0}`,
+		`{"args":["docker","image","inspect","octocat/app:latest"],"exit_code":0}`,
+		`{"args":["docker","push","octocat/app:latest"],"exit_code":0}`,
+	}, "\n")
+
+	e, err := NewReplayExecutor(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatalf("unable to read transcript: %s", err)
+	}
+
+	p := Plugin{
+		SourceImage: "source:1.0",
+		Build: Build{
+			Repo: "octocat/app",
+			Tags: []string{"latest"},
+		},
+		Executor: e,
+	}
+	if err := p.pushOnly(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if remaining := e.Remaining(); len(remaining) != 0 {
+		t.Errorf("Commands not executed: %+v", remaining)
+	}
+}
+
+func TestPushOnlyCleanup(t *testing.T) {
+	transcript := strings.Join([]string{
+		`{"args":["docker","image","inspect","source:1.0"],"exit_code":0}`,
+		`{"args":["docker","tag","source:1.0","octocat/app:latest"],"exit_code":0}`,
+		`{"args":["docker","image","inspect","octocat/app:latest"],"exit_code":0}`,
+		`{"args":["docker","push","octocat/app:latest"],"exit_code":0}`,
+		`{"args":["docker","rmi","octocat/app:latest"],"exit_code":0}`,
+		`{"args":["docker","image","prune","-f"],"exit_code":0}`,
+	}, "\n")
+
+	e, err := NewReplayExecutor(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatalf("unable to read transcript: %s", err)
+	}
+
+	p := Plugin{
+		SourceImage: "source:1.0",
+		Build:       Build{Repo: "octocat/app", Tags: []string{"latest"}},
+		Cleanup:     true,
+		Executor:    e,
+	}
+	if err := p.pushOnly(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if remaining := e.Remaining(); len(remaining) != 0 {
+		t.Errorf("Commands not executed: %+v", remaining)
+	}
+}
+
+func TestExecExecutorCancel(t *testing.T) {
+	cause := errors.New("cancelled by test")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(100*time.Millisecond, func() { cancel(cause) })
+
+	e := execExecutor{gracePeriod: time.Second}
+	start := time.Now()
+	res, err := e.Run(ctx, exec.Command("sleep", "30"))
+	if !errors.Is(err, cause) {
+		t.Errorf("Got error %v, want cancellation cause", err)
+	}
+	if res.ExitCode == 0 {
+		t.Error("expected non-zero exit code for cancelled command")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled command took %s to stop", elapsed)
+	}
+
+	if _, err := e.Run(ctx, exec.Command("true")); !errors.Is(err, cause) {
+		t.Errorf("Got error %v, want cancellation cause for already cancelled context", err)
+	}
+}
+
+func TestExecExecutorCapture(t *testing.T) {
+	e := execExecutor{gracePeriod: time.Second}
+	script := fmt.Sprintf("head -c %d /dev/zero; echo tail", 2*maxTeeCapture)
+
+	// output written to the command's writer is only captured in part
+	var log bytes.Buffer
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Stdout = &log
+	res, err := e.Run(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if log.Len() != 2*maxTeeCapture+5 {
+		t.Errorf("got %d bytes written to the log", log.Len())
+	}
+	if len(res.Stdout) != maxTeeCapture || !strings.HasSuffix(res.Stdout, "tail\n") {
+		t.Errorf("got %d bytes captured, want the last %d", len(res.Stdout), maxTeeCapture)
+	}
+
+	// output requested by the caller is captured in full
+	out, err := runOutput(context.Background(), e, exec.Command("sh", "-c", script))
+	if err != nil || len(out) != 2*maxTeeCapture+5 {
+		t.Errorf("got %d bytes of output, error %v", len(out), err)
+	}
+}
+
+func TestRecordingExecutorStreamedOutput(t *testing.T) {
+	var transcript bytes.Buffer
+	e := NewRecordingExecutor(execExecutor{gracePeriod: time.Second}, &transcript)
+
+	var log bytes.Buffer
+	cmd := exec.Command("sh", "-c", fmt.Sprintf("head -c %d /dev/zero; echo tail", 2*maxTeeCapture))
+	cmd.Stdout = &log
+	if _, err := e.Run(context.Background(), cmd); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	replay, err := NewReplayExecutor(&transcript)
+	if err != nil {
+		t.Fatalf("unable to read transcript: %s", err)
+	}
+	if got := replay.Remaining()[0].Stdout; len(got) != log.Len() {
+		t.Errorf("got %d bytes recorded, want the %d bytes streamed", len(got), log.Len())
+	}
+}
+
+func TestPushOnlyArtifactFallback(t *testing.T) {
+	transcript := strings.Join([]string{
+		`{"args":["docker","image","inspect","source:1.0"],"exit_code":0}`,
+		`{"args":["docker","tag","source:1.0","127.0.0.1:1/octocat/app:1.0"],"exit_code":0}`,
+		`{"args":["docker","image","inspect","127.0.0.1:1/octocat/app:1.0"],"exit_code":0}`,
+		`{"args":["docker","push","127.0.0.1:1/octocat/app:1.0"],"exit_code":0}`,
+		`{"args":["docker","image","inspect","--format","{{json .RepoDigests}}","127.0.0.1:1/octocat/app:1.0"],"stdout":"[\"octocat/app@sha256:bbb\",\"127.0.0.1:1/octocat/app@sha256:aaa\"]\n","exit_code":0}`,
+	}, "\n")
+
+	e, err := NewReplayExecutor(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatalf("unable to read transcript: %s", err)
+	}
+
+	// nothing listens on the registry, so the lookup falls back to docker
+	artifact := filepath.Join(t.TempDir(), "artifact.json")
+	p := Plugin{
+		SourceImage:  "source:1.0",
+		Build:        Build{Repo: "127.0.0.1:1/octocat/app", Tags: []string{"1.0"}},
+		Daemon:       Daemon{Insecure: true},
+		ArtifactFile: artifact,
+		Executor:     e,
+	}
+	if err := p.pushOnly(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if remaining := e.Remaining(); len(remaining) != 0 {
+		t.Errorf("Commands not executed: %+v", remaining)
+	}
+	data, err := os.ReadFile(artifact)
+	if err != nil {
+		t.Fatalf("artifact file not written: %s", err)
+	}
+	if !strings.Contains(string(data), `"digest": "sha256:aaa"`) {
+		t.Errorf("artifact file does not record the local digest:\n%s", data)
+	}
+}
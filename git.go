@@ -0,0 +1,65 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitTags lists the tags of the component in the working directory, with the
+// component prefix stripped, as refs.
+func (p Plugin) gitTags(ctx context.Context, prefix string) ([]string, error) {
+	out, err := runOutput(ctx, p.executor(), exec.CommandContext(ctx, "git", "tag", "--list"))
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for _, tag := range strings.Fields(string(out)) {
+		if ref, ok := ComponentRef("refs/tags/"+tag, prefix); ok {
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+// gitDescribe returns the git describe output for the checkout in the working
+// directory, e.g. v1.2.3-14-gabc1234, considering only the tags of the
+// component when prefix is set.
+func (p Plugin) gitDescribe(ctx context.Context, prefix string) (string, error) {
+	args := []string{"describe", "--tags", "--always"}
+	switch {
+	case prefix == "":
+	case componentSeparated(prefix):
+		args = append(args, "--match", prefix+"*")
+	default:
+		for _, sep := range componentSeparators {
+			args = append(args, "--match", prefix+string(sep)+"*")
+		}
+	}
+	out, err := runOutput(ctx, p.executor(), exec.CommandContext(ctx, "git", args...))
+	if err != nil {
+		return "", fmt.Errorf("git describe failed: %s", err)
+	}
+	version := strings.TrimSpace(string(out))
+	// without a matching tag the output is the abbreviated commit hash
+	if ref, ok := ComponentRef("refs/tags/"+version, prefix); ok {
+		version = strings.TrimPrefix(ref, "refs/tags/")
+	}
+	return version, nil
+}
+
+// gitCommitTime returns the commit timestamp of HEAD in the working directory.
+func (p Plugin) gitCommitTime(ctx context.Context) (time.Time, error) {
+	out, err := runOutput(ctx, p.executor(), exec.CommandContext(ctx, "git", "log", "-1", "--format=%ct"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid commit timestamp: %s", err)
+	}
+	return time.Unix(sec, 0), nil
+}
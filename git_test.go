@@ -0,0 +1,84 @@
+package docker
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitHelpers(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Chdir(t.TempDir())
+
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=octocat", "GIT_AUTHOR_EMAIL=octocat@github.com",
+			"GIT_COMMITTER_NAME=octocat", "GIT_COMMITTER_EMAIL=octocat@github.com",
+			"GIT_AUTHOR_DATE=2024-03-05T10:00:00Z", "GIT_COMMITTER_DATE=2024-03-05T10:00:00Z",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+	git("tag", "billing/v1.0.0")
+	git("tag", "payments/v2.0.0")
+	git("commit", "-q", "--allow-empty", "-m", "second")
+
+	version, err := Plugin{}.gitDescribe(context.Background(), "billing/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(version) < len("v1.0.0-1-g") || version[:len("v1.0.0-1-g")] != "v1.0.0-1-g" {
+		t.Errorf("Got describe %s, want v1.0.0-1-g<sha>", version)
+	}
+
+	refs, err := Plugin{}.gitTags(context.Background(), "billing/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"refs/tags/v1.0.0"}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("Got tags %v, want %v", refs, want)
+	}
+
+	created, err := Plugin{}.gitCommitTime(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := created.UTC().Format("2006-01-02"); got != "2024-03-05" {
+		t.Errorf("Got commit date %s, want 2024-03-05", got)
+	}
+}
+
+func TestGitHelpersExecutor(t *testing.T) {
+	transcript := `{"args":["git","log","-1","--format=%ct"],"stdout":"1709632800\n","exit_code":0}
+{"args":["git","describe","--tags","--always","--match","billing/*"],"stdout":"billing/v1.0.0-1-gabc1234\n","exit_code":0}
+`
+	replay, err := NewReplayExecutor(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Plugin{Executor: replay}
+
+	created, err := p.gitCommitTime(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := created.UTC().Format("2006-01-02"); got != "2024-03-05" {
+		t.Errorf("Got commit date %s, want 2024-03-05", got)
+	}
+	version, err := p.gitDescribe(context.Background(), "billing/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "v1.0.0-1-gabc1234" {
+		t.Errorf("Got describe %s, want v1.0.0-1-gabc1234", version)
+	}
+}
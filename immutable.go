@@ -0,0 +1,188 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Immutable tag protection modes selected with PLUGIN_IMMUTABLE_TAGS.
+const (
+	immutableTagsFail = "fail" // fail the step when a tag would be overwritten
+	immutableTagsSkip = "skip" // do not push the tags that would be overwritten
+)
+
+// defaultMutableTags are the tags that may always be overwritten.
+var defaultMutableTags = []string{"latest"}
+
+// helper function to add tags that may always be overwritten to the mutable
+// tag patterns, keeping the default patterns when none are configured.
+func (p *Plugin) addMutableTags(tags ...string) {
+	if len(p.MutableTags) == 0 {
+		p.MutableTags = append([]string{}, defaultMutableTags...)
+	}
+	p.MutableTags = append(p.MutableTags, tags...)
+}
+
+// existingTag is an immutable tag that already exists in the registry.
+type existingTag struct {
+	Repo   string // Repository of the tag
+	Tag    string // Tag without the repository
+	Digest string // Digest the tag points at in the registry
+}
+
+// isMutableTag reports whether tag matches one of the mutable tag patterns.
+func (p Plugin) isMutableTag(tag string) bool {
+	patterns := p.MutableTags
+	if len(patterns) == 0 {
+		patterns = defaultMutableTags
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// existingTags returns the tags of the targets that already exist in the
+// registry and are not mutable. With config set the digest of an existing tag
+// is the image config digest of its manifest, which is the ID of a local
+// image, otherwise it is the manifest digest. Image indexes have no config
+// digest.
+func (p Plugin) existingTags(ctx context.Context, targets []Destination, config bool) ([]existingTag, error) {
+	client := p.registry()
+	var existing []existingTag
+	for _, target := range targets {
+		for _, tag := range target.Tags {
+			if p.isMutableTag(tag) {
+				continue
+			}
+			ref, err := parseImageRef(fmt.Sprintf("%s:%s", target.Repo, tag))
+			if err != nil {
+				return nil, err
+			}
+			var digest string
+			var found bool
+			if config {
+				var m manifest
+				m, found, err = client.manifest(ctx, ref)
+				digest = m.Config.Digest
+			} else {
+				digest, found, err = client.manifestDigest(ctx, ref)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to check whether %s:%s exists: %w", target.Repo, tag, err)
+			}
+			if found {
+				existing = append(existing, existingTag{Repo: target.Repo, Tag: tag, Digest: digest})
+			}
+		}
+	}
+	return existing, nil
+}
+
+// pushByDigest builds the image and pushes it to the build repository by
+// digest, without any tag, and returns the manifest digest. The tags are
+// added with commandTagDigest once they are compared with the existing tags,
+// so they point at the exact image that was compared.
+func (p Plugin) pushByDigest(ctx context.Context) (string, error) {
+	metadataFile := p.MetadataFile
+	if metadataFile == "" {
+		dir, err := os.MkdirTemp("", "drone-buildx")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(dir)
+		metadataFile = filepath.Join(dir, "metadata.json")
+	}
+
+	cmd := commandBuildx(p.Build, p.Builder, true, metadataFile, "", "")
+	// the image is named by the output, not by tags
+	args := []string{}
+	for i := 0; i < len(cmd.Args); i++ {
+		switch cmd.Args[i] {
+		case "--load":
+		case "-t":
+			i++
+		default:
+			args = append(args, cmd.Args[i])
+		}
+	}
+	cmd.Args = append(args, fmt.Sprintf("--output=type=image,name=%s,push-by-digest=true,push=true", p.Build.Repo))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	trace(cmd)
+	if err := runCommand(ctx, p.executor(), cmd); err != nil {
+		return "", err
+	}
+	return getDigest(metadataFile)
+}
+
+// helper function to create the command that points the tags of the targets
+// at an image that was pushed by digest.
+func commandTagDigest(builder Builder, source string, targets []Destination) *exec.Cmd {
+	args := []string{"buildx", "imagetools", "create"}
+	if builder.Name != "" {
+		args = append(args, "--builder", builder.Name)
+	}
+	for _, target := range targets {
+		for _, tag := range target.Tags {
+			args = append(args, "-t", fmt.Sprintf("%s:%s", target.Repo, tag))
+		}
+	}
+	return exec.Command(dockerExe, append(args, source)...)
+}
+
+// imageID returns the ID of a local image, which is its config digest.
+func (p Plugin) imageID(ctx context.Context, image string) (string, error) {
+	cmd := exec.CommandContext(ctx, dockerExe, "image", "inspect", "--format", "{{.Id}}", image)
+	out, err := runOutput(ctx, p.executor(), cmd)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// enforceImmutableTags returns the targets with the tags that may be pushed.
+// Existing tags that already point at digest, the digest of the image about
+// to be pushed of the same kind as the existing digests, may be pushed again.
+// In fail mode an error is returned when any other existing tag would be
+// overwritten, in skip mode those tags are left out.
+func (p Plugin) enforceImmutableTags(targets []Destination, existing []existingTag, digest string) ([]Destination, error) {
+	var protected []existingTag
+	var names []string
+	for _, t := range existing {
+		if t.Digest != digest || digest == "" {
+			protected = append(protected, t)
+			names = append(names, fmt.Sprintf("%s:%s (%s)", t.Repo, t.Tag, t.Digest))
+		}
+	}
+	if len(protected) == 0 {
+		return targets, nil
+	}
+	if p.ImmutableTags != immutableTagsSkip {
+		return nil, fmt.Errorf("refusing to overwrite immutable tags: %s", strings.Join(names, ", "))
+	}
+
+	fmt.Printf("Skipping immutable tags that already exist: %s\n", strings.Join(names, ", "))
+	allowed := make([]Destination, len(targets))
+	for i, target := range targets {
+		allowed[i] = target
+		allowed[i].Tags = nil
+		for _, tag := range target.Tags {
+			skip := false
+			for _, t := range protected {
+				skip = skip || (t.Repo == target.Repo && t.Tag == tag)
+			}
+			if !skip {
+				allowed[i].Tags = append(allowed[i].Tags, tag)
+			}
+		}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,190 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestEnforceImmutableTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		mutable []string
+		config  bool
+		digest  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "fail",
+			mode:    immutableTagsFail,
+			wantErr: true,
+		},
+		{
+			name: "skip",
+			mode: immutableTagsSkip,
+			want: []string{"latest", "1.1"},
+		},
+		{
+			name:    "mutable patterns",
+			mode:    immutableTagsFail,
+			mutable: []string{"latest", "1.*"},
+			want:    []string{"latest", "1.0", "1.1"},
+		},
+		{
+			name:   "same digest",
+			mode:   immutableTagsFail,
+			digest: "sha256:aaa",
+			want:   []string{"latest", "1.0", "1.1"},
+		},
+		{
+			name:    "config digest is not a manifest digest",
+			mode:    immutableTagsFail,
+			digest:  "sha256:ccc",
+			wantErr: true,
+		},
+		{
+			name:   "same image config",
+			mode:   immutableTagsFail,
+			config: true,
+			digest: "sha256:ccc",
+			want:   []string{"latest", "1.0", "1.1"},
+		},
+		{
+			name:    "manifest digest is not a config digest",
+			mode:    immutableTagsFail,
+			config:  true,
+			digest:  "sha256:aaa",
+			wantErr: true,
+		},
+	}
+
+	srv := newTestRegistry(t, false, map[string]string{"latest": "sha256:aaa", "1.0": "sha256:aaa"}, map[string]string{
+		"1.0": `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"digest":"sha256:ccc"}}`,
+	})
+	registry := srv.Listener.Addr().String()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := Plugin{
+				Login:         Login{Registry: registry, Username: "octocat", Password: "secret"},
+				Daemon:        Daemon{Insecure: true},
+				ImmutableTags: test.mode,
+				MutableTags:   test.mutable,
+			}
+			targets := []Destination{{Repo: registry + "/team/app", Tags: []string{"latest", "1.0", "1.1"}}}
+			existing, err := p.existingTags(context.Background(), targets, test.config)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got, err := p.enforceImmutableTags(targets, existing, test.digest)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got[0].Tags, test.want) {
+				t.Errorf("got tags %q, want %q", got[0].Tags, test.want)
+			}
+		})
+	}
+}
+
+func TestAddMutableTags(t *testing.T) {
+	var p Plugin
+	p.addMutableTags("feature-login")
+	if want := []string{"latest", "feature-login"}; !reflect.DeepEqual(p.MutableTags, want) {
+		t.Errorf("got mutable tags %q, want %q", p.MutableTags, want)
+	}
+	if !p.isMutableTag("feature-login") || !p.isMutableTag("latest") || p.isMutableTag("1.0") {
+		t.Errorf("unexpected mutable tags for patterns %q", p.MutableTags)
+	}
+
+	p = Plugin{MutableTags: []string{"dev-*"}}
+	p.addMutableTags("feature-login")
+	if want := []string{"dev-*", "feature-login"}; !reflect.DeepEqual(p.MutableTags, want) {
+		t.Errorf("got mutable tags %q, want %q", p.MutableTags, want)
+	}
+}
+
+// metadataExecutor writes a build metadata file with its digest for every
+// command that has a metadata file, recording the command lines. Logins
+// succeed.
+type metadataExecutor struct {
+	digest string
+	args   [][]string
+}
+
+func (e *metadataExecutor) Run(ctx context.Context, cmd *exec.Cmd) (Result, error) {
+	e.args = append(e.args, cmd.Args)
+	for i, arg := range cmd.Args[:len(cmd.Args)-1] {
+		if arg == "--metadata-file" {
+			os.WriteFile(cmd.Args[i+1], []byte(`{"containerimage.digest": "`+e.digest+`"}`), 0600)
+		}
+	}
+	if isCommandLogin(cmd.Args) {
+		return Result{Args: cmd.Args, Stdout: "Login Succeeded\n"}, nil
+	}
+	return Result{Args: cmd.Args}, nil
+}
+
+func TestPushByDigest(t *testing.T) {
+	e := &metadataExecutor{digest: "sha256:ccc"}
+	p := Plugin{
+		Build:    Build{Repo: "octocat/app", Tags: []string{"1.0"}, ExtraTags: []string{"quay.io/octocat/app:1.0"}, Context: "."},
+		Executor: e,
+	}
+	digest, err := p.pushByDigest(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if digest != "sha256:ccc" {
+		t.Errorf("got digest %s, want sha256:ccc", digest)
+	}
+	if len(e.args) != 1 || contains(e.args[0], "--push") || contains(e.args[0], "-t") ||
+		!contains(e.args[0], "--output=type=image,name=octocat/app,push-by-digest=true,push=true") {
+		t.Errorf("got commands %q, want a single build pushed by digest", e.args)
+	}
+}
+
+func TestExecImmutableTags(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	srv := newTestRegistry(t, false, map[string]string{"1.0": "sha256:aaa"}, nil)
+	registry := srv.Listener.Addr().String()
+
+	e := &metadataExecutor{digest: "sha256:bbb"}
+	p := Plugin{
+		Login:         Login{Registry: registry, Username: "octocat", Password: "secret"},
+		Build:         Build{Repo: registry + "/team/app", Tags: []string{"1.0", "1.1"}, Context: "."},
+		Daemon:        Daemon{Disabled: true, Insecure: true},
+		ImmutableTags: immutableTagsSkip,
+		Executor:      e,
+	}
+	if err := p.Exec(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var builds, tags [][]string
+	for _, args := range e.args {
+		switch {
+		case isCommandBuildxBuild(args):
+			builds = append(builds, args)
+		case len(args) > 3 && args[2] == "imagetools":
+			tags = append(tags, args)
+		}
+	}
+	if len(builds) != 1 || contains(builds[0], "--push") {
+		t.Errorf("got builds %q, want a single build pushed by digest", builds)
+	}
+	want := []string{dockerExe, "buildx", "imagetools", "create", "-t", registry + "/team/app:1.1", registry + "/team/app@sha256:bbb"}
+	if len(tags) != 1 || !reflect.DeepEqual(tags[0], want) {
+		t.Errorf("got tag commands %q, want %q", tags, want)
+	}
+}
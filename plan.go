@@ -0,0 +1,141 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// planBuilderName is reported as the name of the buildx builder created in
+// plan mode, so the commands that use the builder can still be planned.
+const planBuilderName = "<builder>"
+
+type (
+	// Plan is the ordered list of commands a plugin step would run.
+	Plan struct {
+		Commands []PlanCommand `json:"commands"`
+		Skipped  string        `json:"skipped,omitempty"` // Reason the step runs no commands
+	}
+
+	// PlanCommand is a single command in the plan.
+	PlanCommand struct {
+		Args  []string `json:"args"`            // Command line, redacted
+		Stdin bool     `json:"stdin,omitempty"` // Input is passed on standard input, e.g. a password
+	}
+
+	// planExecutor records commands without running them. Every command
+	// succeeds with empty output, except buildx create which reports the
+	// requested builder name or a placeholder and login which reports success.
+	planExecutor struct {
+		mu       sync.Mutex
+		commands []PlanCommand
+	}
+)
+
+// Run records cmd in the plan.
+func (e *planExecutor) Run(ctx context.Context, cmd *exec.Cmd) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{Args: cmd.Args, ExitCode: -1}, context.Cause(ctx)
+	}
+
+	e.mu.Lock()
+	e.commands = append(e.commands, PlanCommand{
+		Args:  redactArgs(cmd.Args),
+		Stdin: cmd.Stdin != nil,
+	})
+	e.mu.Unlock()
+
+	res := Result{Args: cmd.Args, Started: time.Now()}
+	switch {
+	case isCommandBuildxCreate(cmd.Args):
+		name := planBuilderName
+		for i, arg := range cmd.Args[:len(cmd.Args)-1] {
+			if arg == "--name" {
+				name = cmd.Args[i+1]
+			}
+		}
+		res.Stdout = name + "\n"
+	case isCommandLogin(cmd.Args):
+		res.Stdout = "Login Succeeded\n"
+	}
+	if cmd.Stdout != nil {
+		io.WriteString(cmd.Stdout, res.Stdout)
+	}
+	return res, nil
+}
+
+// Plan resolves the plugin configuration and returns the commands Exec would
+// run, without starting the Docker daemon, executing anything or querying the
+// registry. Nothing is written to the filesystem: neither the Docker config
+// file, the tar output directory nor the files written after a build.
+func (p Plugin) Plan(ctx context.Context) (Plan, error) {
+	planner := &planExecutor{}
+	p.Executor = planner
+	p.Daemon.Disabled = true
+	p.ArtifactFile = ""
+	p.CacheMetricsFile = ""
+	p.CardPath = ""
+	// the registry is not queried while planning
+	p.ImmutableTags = ""
+	p.VerifyPush = false
+
+	err := p.ExecContext(ctx)
+
+	planner.mu.Lock()
+	defer planner.mu.Unlock()
+	return Plan{Commands: planner.commands}, err
+}
+
+// helper function that reports whether the commands are only planned.
+func (p Plugin) planning() bool {
+	_, ok := p.Executor.(*planExecutor)
+	return ok
+}
+
+// WritePlan plans the step and writes the plan to the plan output.
+func (p Plugin) WritePlan(ctx context.Context) error {
+	plan, err := p.Plan(ctx)
+	if err != nil {
+		return err
+	}
+	return writePlan(p.planOutput(), plan)
+}
+
+// WriteSkippedPlan writes an empty plan for a step that is skipped, with the
+// reason it is skipped.
+func (p Plugin) WriteSkippedPlan(reason string) error {
+	return writePlan(p.planOutput(), Plan{Skipped: reason})
+}
+
+// helper function that returns the writer the plan is written to.
+func (p Plugin) planOutput() io.Writer {
+	if p.PlanOutput != nil {
+		return p.PlanOutput
+	}
+	return os.Stdout
+}
+
+// writePlan writes the plan as indented JSON.
+func writePlan(w io.Writer, plan Plan) error {
+	if plan.Commands == nil {
+		plan.Commands = []PlanCommand{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
+
+// helper to check if args match "docker login"
+func isCommandLogin(args []string) bool {
+	return len(args) > 1 && args[1] == "login"
+}
+
+// helper to check if args match "docker buildx create"
+func isCommandBuildxCreate(args []string) bool {
+	return len(args) > 2 && args[1] == "buildx" && args[2] == "create"
+}
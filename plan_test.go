@@ -0,0 +1,118 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	resetSecrets(t)
+	p := Plugin{
+		Login: Login{Username: "octocat", Password: "hunter2secret"},
+		Build: Build{
+			Name:    "00000000",
+			Repo:    "octocat/app",
+			Tags:    []string{"latest"},
+			CacheTo: []string{"type=s3,secret_access_key=hunter2secret"},
+		},
+		Builder: Builder{Driver: dockerContainerDriver, Name: "plan"},
+		Dryrun:  true,
+		Daemon:  Daemon{RetryCount: 1},
+	}
+
+	plan, err := p.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := [][]string{
+		{"docker", "info"},
+		{"docker", "buildx", "create"},
+		{"docker", "buildx", "inspect", "--bootstrap", "--builder", "plan"},
+		{"docker", "version"},
+		{"docker", "info"},
+		{"docker", "buildx", "build"},
+		{"docker", "buildx", "rm", "plan"},
+	}
+	if len(plan.Commands) != len(want) {
+		t.Fatalf("Got %d commands, want %d: %+v", len(plan.Commands), len(want), plan.Commands)
+	}
+	for i, cmd := range plan.Commands {
+		if len(cmd.Args) < len(want[i]) || !reflect.DeepEqual(cmd.Args[:len(want[i])], want[i]) {
+			t.Errorf("Got command %d %v, want prefix %v", i+1, cmd.Args, want[i])
+		}
+	}
+	// the credentials are written to config.json, so there is no login
+	for _, cmd := range plan.Commands {
+		if isCommandLogin(cmd.Args) {
+			t.Errorf("unexpected login %v", cmd.Args)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writePlan(&buf, plan); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(buf.String(), "hunter2secret") {
+		t.Errorf("plan contains a secret:\n%s", buf.String())
+	}
+}
+
+func TestPlanWritesNoFiles(t *testing.T) {
+	for _, format := range []string{"", "oci"} {
+		dir := filepath.Join(t.TempDir(), "out")
+		p := Plugin{
+			Build:              Build{Name: "00000000", Repo: "octocat/app", Tags: []string{"latest"}},
+			Builder:            Builder{Driver: defaultDriver},
+			Dryrun:             true,
+			TarPath:            filepath.Join(dir, "image.tar"),
+			BuildxOutputFormat: format,
+			Daemon:             Daemon{RetryCount: 1},
+		}
+		if _, err := p.Plan(context.Background()); err != nil {
+			t.Fatalf("format %q: unexpected error: %s", format, err)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("format %q: expected tar directory not to be created", format)
+		}
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	var buf bytes.Buffer
+	p := Plugin{
+		Build:      Build{Name: "00000000", Repo: "octocat/app", Tags: []string{"latest"}},
+		Builder:    Builder{Driver: defaultDriver},
+		Dryrun:     true,
+		Daemon:     Daemon{RetryCount: 1},
+		PlanOutput: &buf,
+	}
+	if err := p.WritePlan(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(buf.Bytes(), &plan); err != nil {
+		t.Fatalf("plan output is not JSON: %s\n%s", err, buf.String())
+	}
+	if len(plan.Commands) == 0 || plan.Skipped != "" {
+		t.Errorf("Got plan %+v, want commands", plan)
+	}
+
+	buf.Reset()
+	if err := p.WriteSkippedPlan("the tag does not match prefix billing"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	plan = Plan{}
+	if err := json.Unmarshal(buf.Bytes(), &plan); err != nil {
+		t.Fatalf("plan output is not JSON: %s\n%s", err, buf.String())
+	}
+	if plan.Commands == nil || len(plan.Commands) != 0 || plan.Skipped != "the tag does not match prefix billing" {
+		t.Errorf("Got plan %+v, want an empty skipped plan", plan)
+	}
+}
@@ -0,0 +1,149 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PullRegistry holds the credentials of a registry images are pulled from.
+// The registries are set with PLUGIN_PULL_REGISTRIES as a JSON or YAML list.
+type PullRegistry struct {
+	Registry      string `yaml:"registry"`       // Registry address
+	Username      string `yaml:"username"`       // Registry username
+	Password      string `yaml:"password"`       // Registry password
+	PasswordEnv   string `yaml:"password_env"`   // Environment variable holding the registry password
+	IdentityToken string `yaml:"identity_token"` // OAuth refresh token, used instead of the password
+}
+
+// ParsePullRegistries parses the JSON or YAML list of pull registries.
+// Passwords given with password_env are read from the environment.
+func ParsePullRegistries(s string) ([]PullRegistry, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var registries []PullRegistry
+	// JSON is a subset of YAML, so both formats are decoded the same way
+	dec := yaml.NewDecoder(strings.NewReader(s))
+	dec.KnownFields(true)
+	if err := dec.Decode(&registries); err != nil {
+		return nil, fmt.Errorf("invalid pull registries: %s", err)
+	}
+	for i, r := range registries {
+		if r.PasswordEnv == "" || r.Password != "" {
+			continue
+		}
+		if registries[i].Password = os.Getenv(r.PasswordEnv); registries[i].Password == "" {
+			return nil, fmt.Errorf("password for pull registry %s: environment variable %s is empty", r.Registry, r.PasswordEnv)
+		}
+	}
+	return registries, nil
+}
+
+// pullRegistries returns every registry the plugin logs in to for pulling
+// images, starting with the base image registry.
+func (p Plugin) pullRegistries() []PullRegistry {
+	var registries []PullRegistry
+	if p.BaseImageRegistry != "" {
+		registries = append(registries, PullRegistry{
+			Registry: p.BaseImageRegistry,
+			Username: p.BaseImageUsername,
+			Password: p.BaseImagePassword,
+		})
+	}
+	return append(registries, p.PullRegistries...)
+}
+
+// loginPullRegistries logs in to every pull registry that is not configured
+// in the generated config.json, which buildx forwards to BuildKit for the
+// docker-container and remote drivers.
+func (p Plugin) loginPullRegistries(ctx context.Context, configured map[string]bool) error {
+	for _, r := range p.pullRegistries() {
+		if r.Username == "" || r.Password == "" || configured[normalizeRegistry(r.Registry)] {
+			continue
+		}
+		login := Login{Registry: r.Registry, Username: r.Username, Password: r.Password}
+		if err := p.login(ctx, "base image registry", commandLogin(login)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pullsFromDockerHub reports whether the Dockerfile pulls images from Docker
+// Hub without Docker Hub credentials.
+func (p Plugin) pullsFromDockerHub() bool {
+	for _, r := range p.pullRegistries() {
+		if normalizeRegistry(r.Registry) == dockerHubRegistry {
+			return false
+		}
+	}
+	if p.Login.Password != "" && normalizeRegistry(p.Login.Registry) == dockerHubRegistry {
+		return false
+	}
+	dockerfile := p.Build.Dockerfile
+	if dockerfile == "" {
+		dockerfile = filepath.Join(p.Build.Context, "Dockerfile")
+	}
+	for _, image := range dockerfileImages(dockerfile) {
+		if ref, err := parseImageRef(image); err == nil && ref.Registry == dockerHubRegistry {
+			return true
+		}
+	}
+	return false
+}
+
+// dockerfileImages returns the images referenced by the FROM instructions of
+// a Dockerfile. Build stages, scratch and images whose repository is set with
+// build arguments are left out.
+func dockerfileImages(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var images []string
+	stages := map[string]bool{"scratch": true}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		image := fields[0]
+		if !stages[strings.ToLower(image)] && !strings.Contains(imageRepository(image), "$") {
+			images = append(images, image)
+		}
+		if len(fields) >= 3 && strings.EqualFold(fields[1], "AS") {
+			stages[strings.ToLower(fields[2])] = true
+		}
+	}
+	return images
+}
+
+// helper function that returns the image without its tag or digest. The tag
+// starts at the first colon after the last slash, so a tag set with a build
+// argument default such as ${GO_VERSION:-1.22} is cut as a whole.
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	slash := strings.LastIndex(image, "/")
+	if i := strings.Index(image[slash+1:], ":"); i >= 0 {
+		image = image[:slash+1+i]
+	}
+	return image
+}
@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePullRegistries(t *testing.T) {
+	t.Setenv("QUAY_PASSWORD", "hunter2")
+	want := []PullRegistry{
+		{Registry: "ghcr.io", Username: "octocat", Password: "token"},
+		{Registry: "quay.io", Username: "robot", Password: "hunter2", PasswordEnv: "QUAY_PASSWORD"},
+	}
+
+	for _, s := range []string{
+		`[{"registry": "ghcr.io", "username": "octocat", "password": "token"}, {"registry": "quay.io", "username": "robot", "password_env": "QUAY_PASSWORD"}]`,
+		"- registry: ghcr.io\n  username: octocat\n  password: token\n- registry: quay.io\n  username: robot\n  password_env: QUAY_PASSWORD\n",
+	} {
+		got, err := ParsePullRegistries(s)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+
+	if _, err := ParsePullRegistries(`[{"registry": "ghcr.io", "user": "octocat"}]`); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestDockerfileImages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Dockerfile")
+	os.WriteFile(path, []byte(`ARG GO_VERSION=1.22
+FROM --platform=$BUILDPLATFORM golang:${GO_VERSION} AS build
+FROM ghcr.io/octocat/base:1.0 AS base
+from base AS final
+FROM scratch
+COPY --from=build /app /app
+FROM alpine:3.19
+FROM ${REGISTRY}/octocat/app:1.0
+FROM node:${NODE_VERSION:-20}
+`), 0600)
+
+	want := []string{"golang:${GO_VERSION}", "ghcr.io/octocat/base:1.0", "alpine:3.19", "node:${NODE_VERSION:-20}"}
+	if got := dockerfileImages(path); !reflect.DeepEqual(got, want) {
+		t.Errorf("got images %q, want %q", got, want)
+	}
+}
+
+func TestPullsFromDockerHub(t *testing.T) {
+	dir := t.TempDir()
+	hub := filepath.Join(dir, "Dockerfile.hub")
+	os.WriteFile(hub, []byte("FROM node:20\n"), 0600)
+	private := filepath.Join(dir, "Dockerfile.private")
+	os.WriteFile(private, []byte("FROM registry.example.com/team/node:20\n"), 0600)
+
+	tests := []struct {
+		name   string
+		plugin Plugin
+		want   bool
+	}{
+		{
+			name:   "docker hub image",
+			plugin: Plugin{Build: Build{Dockerfile: hub}},
+			want:   true,
+		},
+		{
+			name:   "private image",
+			plugin: Plugin{Build: Build{Dockerfile: private}},
+		},
+		{
+			name: "docker hub credentials",
+			plugin: Plugin{
+				Build:          Build{Dockerfile: hub},
+				PullRegistries: []PullRegistry{{Registry: "docker.io", Username: "octocat", Password: "token"}},
+			},
+		},
+		{
+			name:   "missing dockerfile",
+			plugin: Plugin{Build: Build{Dockerfile: filepath.Join(dir, "Dockerfile")}},
+		},
+	}
+	for _, test := range tests {
+		if got := test.plugin.pullsFromDockerHub(); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+}
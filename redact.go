@@ -0,0 +1,141 @@
+package docker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// redactedValue replaces secrets in logs, errors and files written by the plugin.
+const redactedValue = "******"
+
+// minSecretLength is the shortest value that is registered as a secret. Shorter
+// values would mask unrelated text throughout the output.
+const minSecretLength = 4
+
+// redactor is a registry of secret values that must never be echoed.
+type redactor struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// secrets is the central registry used by trace, error reporting and the
+// files written by the plugin.
+var secrets = &redactor{}
+
+// credentialKey matches the keys of credentialPair and credentialField.
+const credentialKey = `[-\w.]*(?:(?:passw(?:or)?d|secret|token|credential|api_?key|access_?key|account_?key|json_?key|private_?key|ca_cert)[-\w.]*|auth(?:orization)?(?:[-_.][-\w.]*)?)`
+
+// credentialPair matches key=value pairs whose key looks like it carries a
+// credential, e.g. secret_access_key=..., env.AWS_SESSION_TOKEN=... or
+// --aws-token-content=... in buildx driver and cache options.
+// A bare auth must end the key or be followed by a separator, so that keys
+// such as org.opencontainers.image.authors are not masked.
+var credentialPair = regexp.MustCompile(`(?i)((?:^|[\s,"'])` + credentialKey + `=)([^\s,"']+)`)
+
+// credentialField matches JSON string fields whose key looks like it carries
+// a credential, e.g. the "Secret" returned by a Docker credential helper.
+var credentialField = regexp.MustCompile(`(?i)("` + credentialKey + `"\s*:\s*")((?:[^"\\]|\\.)+)"`)
+
+// addSecret registers values that must be masked in all output.
+func addSecret(values ...string) {
+	secrets.add(values...)
+}
+
+// redact masks registered secrets and credential-like key=value pairs in s.
+func redact(s string) string {
+	return secrets.redact(s)
+}
+
+// redactArgs returns a redacted copy of a command line.
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = redact(arg)
+	}
+	return out
+}
+
+func (r *redactor) add(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) < minSecretLength {
+			continue
+		}
+		// files such as the card are redacted after being marshalled, where
+		// quotes, backslashes and newlines of the secret are escaped
+		for _, form := range []string{v, jsonEscape(v)} {
+			if !contains(r.values, form) {
+				r.values = append(r.values, form)
+			}
+		}
+	}
+	// replace longer values first so a secret containing another secret
+	// is masked as a whole
+	sort.Slice(r.values, func(i, j int) bool {
+		return len(r.values[i]) > len(r.values[j])
+	})
+}
+
+// helper function that returns s as escaped inside a JSON string.
+func jsonEscape(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data[1 : len(data)-1])
+}
+
+func (r *redactor) redact(s string) string {
+	r.mu.RLock()
+	for _, v := range r.values {
+		s = strings.ReplaceAll(s, v, redactedValue)
+	}
+	r.mu.RUnlock()
+	s = credentialPair.ReplaceAllStringFunc(s, func(m string) string {
+		parts := credentialPair.FindStringSubmatch(m)
+		if parts[2] == redactedValue {
+			return m
+		}
+		return parts[1] + redactedValue
+	})
+	return credentialField.ReplaceAllString(s, `${1}`+redactedValue+`"`)
+}
+
+// registerSecrets adds every credential known to the plugin to the registry.
+func (p Plugin) registerSecrets() {
+	addSecret(
+		p.Login.Password,
+		p.Login.AccessToken,
+		p.BaseImagePassword,
+		p.Build.SSHAgentKey,
+		p.Build.HarnessSelfHostedS3AccessKey,
+		p.Build.HarnessSelfHostedS3SecretKey,
+		p.Build.HarnessSelfHostedGcpJsonKey,
+		p.Build.HarnessSelfHostedAzureAccountKey,
+		p.Build.HarnessSelfHostedAzureClientSecret,
+		p.Build.HarnessSelfHostedAzureOidcToken,
+	)
+	for _, d := range p.Destinations {
+		addSecret(d.Password)
+	}
+	for _, r := range p.PullRegistries {
+		addSecret(r.Password)
+	}
+	if p.Build.HarnessSelfHostedGcpJsonKey != "" {
+		// the key is passed base64 encoded in the cache options
+		addSecret(base64.StdEncoding.EncodeToString([]byte(p.Build.HarnessSelfHostedGcpJsonKey)))
+	}
+}
+
+// helper function that reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r := &redactor{}
+	r.add("hunter2secret", "abc", "  ", "hunter2")
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "registered_secret",
+			in:   "docker login -u octocat -p hunter2secret registry.example.com",
+			want: "docker login -u octocat -p ****** registry.example.com",
+		},
+		{
+			name: "longest_secret_first",
+			in:   "hunter2 hunter2secret",
+			want: "****** ******",
+		},
+		{
+			name: "short_values_are_ignored",
+			in:   "abc is not a secret",
+			want: "abc is not a secret",
+		},
+		{
+			name: "credential_pair",
+			in:   "--cache-to type=s3,access_key_id=AKIA,secret_access_key=s3cr3t,region=us-east-1",
+			want: "--cache-to type=s3,access_key_id=******,secret_access_key=******,region=us-east-1",
+		},
+		{
+			name: "driver_opt_env",
+			in:   "--driver-opt env.AWS_SESSION_TOKEN=tok --driver-opt env.HARNESS_CA_CERT=pem",
+			want: "--driver-opt env.AWS_SESSION_TOKEN=****** --driver-opt env.HARNESS_CA_CERT=******",
+		},
+		{
+			name: "buildkitd_flag",
+			in:   "--buildkitd-flags --aws-token-content=eyJhbGciOi",
+			want: "--buildkitd-flags --aws-token-content=******",
+		},
+		{
+			name: "auth_keys",
+			in:   "--build-arg NPM_AUTH=abc --build-arg auth_token=abc --cache-to type=gha,auth=abc",
+			want: "--build-arg NPM_AUTH=****** --build-arg auth_token=****** --cache-to type=gha,auth=******",
+		},
+		{
+			name: "authors_label",
+			in:   "--label org.opencontainers.image.authors=octocat --build-arg AUTHOR=octocat",
+			want: "--label org.opencontainers.image.authors=octocat --build-arg AUTHOR=octocat",
+		},
+		{
+			name: "credential_field",
+			in:   `{"ServerURL":"gcr.io","Username":"_json_key","Secret":"s3cr\"et"}`,
+			want: `{"ServerURL":"gcr.io","Username":"_json_key","Secret":"******"}`,
+		},
+		{
+			name: "plain_fields",
+			in:   `{"Author": "octocat", "Digest": "sha256:aaa"}`,
+			want: `{"Author": "octocat", "Digest": "sha256:aaa"}`,
+		},
+		{
+			name: "plain_build_args",
+			in:   "--build-arg VERSION=1.0 --label org.opencontainers.image.source=repo",
+			want: "--build-arg VERSION=1.0 --label org.opencontainers.image.source=repo",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := r.redact(tc.in); got != tc.want {
+				t.Errorf("Got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// helper function that replaces the secret registry for the test.
+func resetSecrets(t *testing.T) {
+	saved := secrets
+	secrets = &redactor{}
+	t.Cleanup(func() { secrets = saved })
+}
+
+func TestRegisterSecrets(t *testing.T) {
+	resetSecrets(t)
+	p := Plugin{
+		Login: Login{Password: "registry-password"},
+		Build: Build{HarnessSelfHostedGcpJsonKey: `{"type":"service_account"}`},
+	}
+	p.registerSecrets()
+
+	if got := redact("-p registry-password"); got != "-p ******" {
+		t.Errorf("Got %q, want password to be masked", got)
+	}
+	if got := redact("json_key=eyJ0eXBlIjoic2VydmljZV9hY2NvdW50In0="); got != "json_key=******" {
+		t.Errorf("Got %q, want encoded key to be masked", got)
+	}
+	if got := redact("eyJ0eXBlIjoic2VydmljZV9hY2NvdW50In0="); got != redactedValue {
+		t.Errorf("Got %q, want base64 encoded key to be masked", got)
+	}
+}
+
+func TestWriteCardRedactsEscapedSecrets(t *testing.T) {
+	resetSecrets(t)
+	secret := "-----BEGIN KEY-----\n\"quoted\\value\"\n-----END KEY-----"
+	addSecret(secret)
+
+	path := filepath.Join(t.TempDir(), "card.json")
+	writeCard(path, map[string]string{"Command": "echo " + secret})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "quoted") {
+		t.Errorf("Got card %s, want secret to be masked", data)
+	}
+	if want := `{"Command":"echo ******"}`; string(data) != want {
+		t.Errorf("Got card %s, want %s", data, want)
+	}
+}
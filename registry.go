@@ -0,0 +1,520 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-plugins/drone-buildx/config/docker"
+)
+
+// dockerHubRegistry is the registry host used for images without a registry.
+const dockerHubRegistry = "docker.io"
+
+// manifestMediaTypes are accepted when resolving manifests, so that multi
+// platform indexes are returned as is rather than resolved to one platform.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// authParam matches the key="value" pairs of a WWW-Authenticate header.
+var authParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+type (
+	// imageRef is an image reference split into its parts.
+	imageRef struct {
+		Registry   string // Registry host, docker.io for Docker Hub
+		Repository string // Repository path, e.g. library/alpine
+		Reference  string // Tag or digest
+	}
+
+	// manifest is the part of an image manifest or index used by the plugin.
+	manifest struct {
+		MediaType string               `json:"mediaType"`
+		Config    manifestDescriptor   `json:"config"`
+		Manifests []manifestDescriptor `json:"manifests"`
+		Digest    string               `json:"-"` // Digest of the manifest itself
+	}
+
+	// manifestDescriptor references the config of a manifest or a platform
+	// manifest of an index.
+	manifestDescriptor struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Platform  *struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	}
+
+	// pushedImage holds the digests of an image as stored in the registry.
+	pushedImage struct {
+		Repo      string            // Repository the image was pushed to
+		Digest    string            // Digest of the manifest or index
+		Tags      map[string]string // Digest each tag points at
+		Platforms map[string]string // Platform manifest digests by os/arch[/variant]
+	}
+
+	// registryClient queries registries using the distribution v2 API.
+	registryClient struct {
+		client      *http.Client
+		credentials func(ctx context.Context, registry string) docker.RegistryCredentials
+		insecure    bool // fall back to plain http
+
+		mu     sync.Mutex
+		tokens map[string]string // bearer tokens by registry and scope
+	}
+)
+
+// newRegistryClient returns a client that authenticates with the credentials
+// returned for each registry host.
+func newRegistryClient(credentials func(ctx context.Context, registry string) docker.RegistryCredentials, insecure bool) *registryClient {
+	return &registryClient{
+		client:      &http.Client{Timeout: 30 * time.Second},
+		credentials: credentials,
+		insecure:    insecure,
+		tokens:      map[string]string{},
+	}
+}
+
+// parseImageRef parses an image reference such as octocat/app:1.0 or
+// registry.example.com:5000/team/app@sha256:....
+func parseImageRef(s string) (imageRef, error) {
+	ref := imageRef{Registry: dockerHubRegistry}
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Reference = name[:i], name[i+1:]
+	}
+	if ref.Reference == "" {
+		ref.Reference = "latest"
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.Registry = normalizeRegistry(host)
+			name = name[i+1:]
+		}
+	}
+	if name == "" {
+		return ref, fmt.Errorf("invalid image reference %q", s)
+	}
+	if ref.Registry == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.Repository = name
+	return ref, nil
+}
+
+func (r imageRef) String() string {
+	sep := ":"
+	if strings.Contains(r.Reference, ":") {
+		sep = "@"
+	}
+	return fmt.Sprintf("%s/%s%s%s", r.Registry, r.Repository, sep, r.Reference)
+}
+
+// normalizeRegistry returns the registry host of a registry address, mapping
+// the Docker Hub aliases to docker.io.
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(registry, "https://")
+	registry = strings.TrimPrefix(registry, "http://")
+	registry = strings.SplitN(registry, "/", 2)[0]
+	switch registry {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubRegistry
+	}
+	return registry
+}
+
+// helper function that returns the API endpoint of a registry host.
+func registryEndpoint(registry string) string {
+	if registry == dockerHubRegistry {
+		return "registry-1.docker.io"
+	}
+	return registry
+}
+
+// manifest fetches the manifest or index a reference points at. found is
+// false when the registry does not know the reference.
+func (c *registryClient) manifest(ctx context.Context, ref imageRef) (m manifest, found bool, err error) {
+	res, err := c.manifestRequest(ctx, http.MethodGet, ref)
+	if err != nil {
+		return m, false, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return m, false, nil
+	case res.StatusCode != http.StatusOK:
+		return m, false, fmt.Errorf("unexpected status %s for %s", res.Status, ref)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return m, false, err
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return m, false, fmt.Errorf("invalid manifest for %s: %s", ref, err)
+	}
+	if m.MediaType == "" {
+		m.MediaType = res.Header.Get("Content-Type")
+	}
+	m.Digest = res.Header.Get("Docker-Content-Digest")
+	if m.Digest == "" {
+		m.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+	return m, true, nil
+}
+
+// platforms returns the platform manifest digests of an index by
+// os/arch[/variant]. Attestation manifests, which have an unknown platform,
+// are left out.
+func (m manifest) platforms() map[string]string {
+	out := map[string]string{}
+	for _, d := range m.Manifests {
+		if d.Platform == nil || d.Platform.OS == "unknown" {
+			continue
+		}
+		name := d.Platform.OS + "/" + d.Platform.Architecture
+		if d.Platform.Variant != "" {
+			name += "/" + d.Platform.Variant
+		}
+		out[name] = d.Digest
+	}
+	return out
+}
+
+// manifestDigest returns the digest of the manifest a reference points at.
+// found is false when the registry does not know the reference.
+func (c *registryClient) manifestDigest(ctx context.Context, ref imageRef) (digest string, found bool, err error) {
+	res, err := c.manifestRequest(ctx, http.MethodHead, ref)
+	if err != nil {
+		return "", false, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return "", false, nil
+	case res.StatusCode != http.StatusOK:
+		return "", false, fmt.Errorf("unexpected status %s for %s", res.Status, ref)
+	}
+	if digest := res.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, true, nil
+	}
+
+	// some registries omit the digest header on HEAD requests
+	res, err = c.manifestRequest(ctx, http.MethodGet, ref)
+	if err != nil {
+		return "", false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("unexpected status %s for %s", res.Status, ref)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(body)), true, nil
+}
+
+// helper function to send a manifest request for ref.
+func (c *registryClient) manifestRequest(ctx context.Context, method string, ref imageRef) (*http.Response, error) {
+	path := fmt.Sprintf("/v2/%s/manifests/%s", ref.Repository, ref.Reference)
+	return c.do(ctx, method, ref.Registry, path, fmt.Sprintf("repository:%s:pull", ref.Repository))
+}
+
+// do sends a request to the registry, answering the authentication challenge
+// of the registry when the request is rejected.
+func (c *registryClient) do(ctx context.Context, method, registry, path, scope string) (*http.Response, error) {
+	key := registry + " " + scope
+	res, err := c.send(ctx, method, registry, path, c.authorization(key))
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	challenge := res.Header.Get("WWW-Authenticate")
+	res.Body.Close()
+
+	auth, err := c.authenticate(ctx, registry, challenge, scope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to authenticate to %s: %w", registry, err)
+	}
+	c.mu.Lock()
+	c.tokens[key] = auth
+	c.mu.Unlock()
+	return c.send(ctx, method, registry, path, auth)
+}
+
+// helper function that returns the cached authorization header for key.
+func (c *registryClient) authorization(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens[key]
+}
+
+// helper function to send a single request, falling back to plain http for
+// insecure registries.
+func (c *registryClient) send(ctx context.Context, method, registry, path, auth string) (*http.Response, error) {
+	schemes := []string{"https"}
+	if c.insecure {
+		schemes = append(schemes, "http")
+	}
+	var err error
+	for _, scheme := range schemes {
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s://%s%s", scheme, registryEndpoint(registry), path), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		var res *http.Response
+		if res, err = c.client.Do(req); err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+// authenticate answers a Basic or Bearer challenge and returns the value of
+// the Authorization header to use. A registry token is used as is, and an
+// identity token is exchanged for an access token like a refresh token.
+func (c *registryClient) authenticate(ctx context.Context, registry, challenge, scope string) (string, error) {
+	var creds docker.RegistryCredentials
+	if c.credentials != nil {
+		creds = c.credentials(ctx, registry)
+	}
+	if creds.RegistryToken != "" {
+		return "Bearer " + creds.RegistryToken, nil
+	}
+	username, password := creds.Username, creds.Password
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	switch scheme {
+	case "basic":
+		if username == "" && password == "" {
+			return "", fmt.Errorf("registry requires credentials")
+		}
+		return basic, nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, m := range authParam.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("bearer challenge without realm")
+	}
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", scope)
+
+	var req *http.Request
+	var err error
+	if creds.IdentityToken != "" {
+		query.Set("grant_type", "refresh_token")
+		query.Set("refresh_token", creds.IdentityToken)
+		query.Set("client_id", "drone-buildx")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, params["realm"], strings.NewReader(query.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+		if err == nil && (username != "" || password != "") {
+			req.Header.Set("Authorization", basic)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %s", res.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response: %s", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("token response without token")
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// registryCredentials returns the credentials of the plugin for a registry
+// host: the push registry login, the pull registry logins, the destination
+// logins and finally the credentials stored in the Docker config
+// file.
+func (p Plugin) registryCredentials(ctx context.Context, registry string) docker.RegistryCredentials {
+	creds := docker.RegistryCredentials{Registry: registry}
+	switch {
+	case p.Login.Password != "" && normalizeRegistry(p.Login.Registry) == registry:
+		creds.Username, creds.Password = p.Login.Username, p.Login.Password
+		return creds
+	case p.Login.AccessToken != "" && normalizeRegistry(p.Login.Registry) == registry:
+		creds.Username, creds.Password = "oauth2accesstoken", p.Login.AccessToken
+		return creds
+	}
+	for _, r := range p.pullRegistries() {
+		if (r.Password != "" || r.IdentityToken != "") && normalizeRegistry(r.Registry) == registry {
+			creds.Username, creds.Password, creds.IdentityToken = r.Username, r.Password, r.IdentityToken
+			return creds
+		}
+	}
+	for _, d := range p.Destinations {
+		if (d.Password != "" || d.IdentityToken != "") && d.registry() == registry {
+			creds.Username, creds.Password, creds.IdentityToken = d.Username, d.Password, d.IdentityToken
+			return creds
+		}
+	}
+	return p.dockerConfigCredentials(ctx, filepath.Join(dockerConfigDir(), "config.json"), registry)
+}
+
+// helper function that returns the directory of the Docker config file.
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	return dockerHome
+}
+
+// dockerConfigCredentials returns the credentials stored for a registry host
+// in a Docker config file, as written by the plugin or docker login. Like
+// Docker, a credential helper of the registry is used before the auths, and
+// the credential store is asked for registries without auths.
+func (p Plugin) dockerConfigCredentials(ctx context.Context, path, registry string) docker.RegistryCredentials {
+	creds := docker.RegistryCredentials{Registry: registry}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return creds
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth          string `json:"auth"`
+			Username      string `json:"username"`
+			Password      string `json:"password"`
+			IdentityToken string `json:"identitytoken"`
+			RegistryToken string `json:"registrytoken"`
+		} `json:"auths"`
+		CredHelpers map[string]string `json:"credHelpers"`
+		CredsStore  string            `json:"credsStore"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return creds
+	}
+	for host, helper := range config.CredHelpers {
+		if normalizeRegistry(host) == registry {
+			return p.credHelperCredentials(ctx, helper, host, registry)
+		}
+	}
+	for host, auth := range config.Auths {
+		if normalizeRegistry(host) != registry {
+			continue
+		}
+		creds.Username, creds.Password = auth.Username, auth.Password
+		if decoded, err := base64.StdEncoding.DecodeString(auth.Auth); err == nil && auth.Auth != "" {
+			creds.Username, creds.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		creds.IdentityToken = auth.IdentityToken
+		creds.RegistryToken = auth.RegistryToken
+		// an empty entry only records a login kept in the credential store
+		if creds.Password != "" || creds.IdentityToken != "" || creds.RegistryToken != "" {
+			return creds
+		}
+	}
+	if config.CredsStore != "" {
+		return p.credHelperCredentials(ctx, config.CredsStore, configRegistry(registry), registry)
+	}
+	return creds
+}
+
+// registry returns a registry client using the plugin credentials.
+func (p Plugin) registry() *registryClient {
+	return newRegistryClient(p.registryCredentials, p.Daemon.Insecure)
+}
+
+// inspectPushed looks up the tags of repo in the registry and returns the
+// digests they point at. The image digest and platforms are taken from the
+// first tag.
+func (p Plugin) inspectPushed(ctx context.Context, repo string, tags []string) (pushedImage, error) {
+	image := pushedImage{Repo: repo, Tags: map[string]string{}, Platforms: map[string]string{}}
+	client := p.registry()
+	for _, tag := range tags {
+		ref, err := parseImageRef(fmt.Sprintf("%s:%s", repo, tag))
+		if err != nil {
+			return image, err
+		}
+		m, found, err := client.manifest(ctx, ref)
+		if err != nil {
+			return image, fmt.Errorf("unable to inspect %s:%s: %w", repo, tag, err)
+		}
+		if !found {
+			return image, fmt.Errorf("%s:%s not found in the registry", repo, tag)
+		}
+		if image.Digest == "" {
+			image.Digest = m.Digest
+			image.Platforms = m.platforms()
+		}
+		image.Tags[tag] = m.Digest
+	}
+	if image.Digest == "" {
+		return image, fmt.Errorf("no tags to inspect for %s", repo)
+	}
+	return image, nil
+}
+
+// helper function to print the digests of a pushed image.
+func printPushed(image pushedImage) {
+	for _, tag := range sortedKeys(image.Tags) {
+		fmt.Printf("Pushed %s:%s@%s\n", image.Repo, tag, image.Tags[tag])
+	}
+	for _, platform := range sortedKeys(image.Platforms) {
+		fmt.Printf("  %s: %s\n", platform, image.Platforms[platform])
+	}
+}
+
+// helper function that returns the keys of a map in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,234 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/drone-plugins/drone-buildx/config/docker"
+)
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want imageRef
+	}{
+		{
+			ref:  "alpine",
+			want: imageRef{Registry: "docker.io", Repository: "library/alpine", Reference: "latest"},
+		},
+		{
+			ref:  "octocat/app:1.0",
+			want: imageRef{Registry: "docker.io", Repository: "octocat/app", Reference: "1.0"},
+		},
+		{
+			ref:  "index.docker.io/octocat/app:1.0",
+			want: imageRef{Registry: "docker.io", Repository: "octocat/app", Reference: "1.0"},
+		},
+		{
+			ref:  "registry.example.com:5000/team/app:v2",
+			want: imageRef{Registry: "registry.example.com:5000", Repository: "team/app", Reference: "v2"},
+		},
+		{
+			ref:  "localhost/app@sha256:abc",
+			want: imageRef{Registry: "localhost", Repository: "app", Reference: "sha256:abc"},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := parseImageRef(test.ref)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.ref, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.ref, got, test.want)
+		}
+	}
+
+	if _, err := parseImageRef("registry.example.com/"); err == nil {
+		t.Error("expected an error for a reference without a repository")
+	}
+}
+
+func TestNormalizeRegistry(t *testing.T) {
+	tests := map[string]string{
+		"":                            "docker.io",
+		"https://index.docker.io/v1/": "docker.io",
+		"registry.hub.docker.com":     "docker.io",
+		"http://localhost:5000":       "localhost:5000",
+		"gcr.io":                      "gcr.io",
+	}
+	for in, want := range tests {
+		if got := normalizeRegistry(in); got != want {
+			t.Errorf("normalizeRegistry(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// newTestRegistry returns a registry serving the given tags of team/app,
+// protected by bearer token authentication. Manifests are served from bodies
+// by tag, an empty manifest is served for the other tags.
+func newTestRegistry(t *testing.T, tls bool, tags map[string]string, bodies map[string]string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			// identity tokens are exchanged like OAuth refresh tokens
+			if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		} else if user, pass, ok := r.BasicAuth(); !ok || user != "octocat" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("scope") != "repository:team/app:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "abc"})
+	})
+	mux.HandleFunc("/v2/team/app/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		tag := strings.TrimPrefix(r.URL.Path, "/v2/team/app/manifests/")
+		digest, ok := tags[tag]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		body, ok := bodies[tag]
+		if !ok {
+			body = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`
+		}
+		io.WriteString(w, body)
+	})
+	if tls {
+		srv = httptest.NewTLSServer(mux)
+	} else {
+		srv = httptest.NewServer(mux)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestManifestDigest(t *testing.T) {
+	srv := newTestRegistry(t, true, map[string]string{"1.0": "sha256:aaa"}, nil)
+	host := srv.Listener.Addr().String()
+
+	client := newRegistryClient(func(ctx context.Context, registry string) docker.RegistryCredentials {
+		if registry != host {
+			t.Errorf("credentials requested for %s, want %s", registry, host)
+		}
+		return docker.RegistryCredentials{Username: "octocat", Password: "secret"}
+	}, false)
+	client.client = srv.Client()
+
+	ref, _ := parseImageRef(host + "/team/app:1.0")
+	digest, found, err := client.manifestDigest(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !found || digest != "sha256:aaa" {
+		t.Errorf("got digest %q found %t, want sha256:aaa", digest, found)
+	}
+
+	ref.Reference = "2.0"
+	if _, found, err := client.manifestDigest(context.Background(), ref); err != nil || found {
+		t.Errorf("got found %t error %v for a missing tag", found, err)
+	}
+
+	client.credentials = func(context.Context, string) docker.RegistryCredentials {
+		return docker.RegistryCredentials{Username: "octocat", Password: "wrong"}
+	}
+	client.tokens = map[string]string{}
+	if _, _, err := client.manifestDigest(context.Background(), ref); err == nil {
+		t.Error("expected an authentication error")
+	}
+
+	// identity and registry tokens from the docker config
+	for _, creds := range []docker.RegistryCredentials{{IdentityToken: "refresh"}, {RegistryToken: "abc"}} {
+		client.credentials = func(context.Context, string) docker.RegistryCredentials { return creds }
+		client.tokens = map[string]string{}
+		ref.Reference = "1.0"
+		if _, found, err := client.manifestDigest(context.Background(), ref); err != nil || !found {
+			t.Errorf("%+v: got found %t error %v", creds, found, err)
+		}
+	}
+}
+
+const testIndex = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.index.v1+json",
+  "manifests": [
+    {"digest": "sha256:amd64", "platform": {"os": "linux", "architecture": "amd64"}},
+    {"digest": "sha256:arm64", "platform": {"os": "linux", "architecture": "arm64", "variant": "v8"}},
+    {"digest": "sha256:attestation", "platform": {"os": "unknown", "architecture": "unknown"}}
+  ]
+}`
+
+func TestInspectPushed(t *testing.T) {
+	srv := newTestRegistry(t, false, map[string]string{"1.0": "sha256:index", "latest": "sha256:index"}, map[string]string{"1.0": testIndex, "latest": testIndex})
+	registry := srv.Listener.Addr().String()
+	p := Plugin{
+		Login:  Login{Registry: registry, Username: "octocat", Password: "secret"},
+		Daemon: Daemon{Insecure: true},
+	}
+
+	got, err := p.inspectPushed(context.Background(), registry+"/team/app", []string{"1.0", "latest"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := pushedImage{
+		Repo:      registry + "/team/app",
+		Digest:    "sha256:index",
+		Tags:      map[string]string{"1.0": "sha256:index", "latest": "sha256:index"},
+		Platforms: map[string]string{"linux/amd64": "sha256:amd64", "linux/arm64/v8": "sha256:arm64"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := p.inspectPushed(context.Background(), registry+"/team/app", []string{"2.0"}); err == nil {
+		t.Error("expected an error for a tag that was not pushed")
+	}
+}
+
+func TestDockerConfigCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "b2N0b2NhdDpzZWNyZXQ="},
+		"registry.example.com": {"username": "robot", "password": "token"},
+		"myregistry.azurecr.io": {"auth": "MDAwMDAwMDAtMDAwMC0wMDAwLTAwMDAtMDAwMDAwMDAwMDAwOg==", "identitytoken": "refresh"},
+		"harbor.example.com": {"registrytoken": "bearer"}
+	}}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []docker.RegistryCredentials{
+		{Registry: "docker.io", Username: "octocat", Password: "secret"},
+		{Registry: "registry.example.com", Username: "robot", Password: "token"},
+		{Registry: "myregistry.azurecr.io", Username: "00000000-0000-0000-0000-000000000000", IdentityToken: "refresh"},
+		{Registry: "harbor.example.com", RegistryToken: "bearer"},
+		{Registry: "gcr.io"},
+	}
+	for _, want := range tests {
+		if got := (Plugin{}).dockerConfigCredentials(context.Background(), path, want.Registry); got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Settings is the structure of the file referenced by
+	// PLUGIN_SETTINGS_FILE. Every key maps onto a field of the Plugin, Build,
+	// Builder, Daemon or Login structs. Keys left out of the file keep the
+	// value of the corresponding flag, and flags set on the command line or
+	// through environment variables take precedence over the file.
+	//
+	// Credentials are deliberately not part of the file, which is meant to be
+	// committed to the repository.
+	Settings struct {
+		DryRun             *bool           `yaml:"dry_run"`
+		Purge              *bool           `yaml:"purge"`
+		PurgePolicy        []string        `yaml:"purge_policy"`
+		PurgeKeepStorage   *string         `yaml:"purge_keep_storage"`
+		PushOnly           *bool           `yaml:"push_only"`
+		SourceImage        *string         `yaml:"source_image"`
+		SourceTarPath      *string         `yaml:"source_tar_path"`
+		TarPath            *string         `yaml:"tar_path"`
+		BuildxOutputFormat *string         `yaml:"buildx_output_format"`
+		MetadataFile       *string         `yaml:"metadata_file"`
+		Timeout            *time.Duration  `yaml:"timeout"`
+		GracePeriod        *time.Duration  `yaml:"timeout_grace_period"`
+		Strict             *bool           `yaml:"strict"`
+		Login              LoginSettings   `yaml:"login"`
+		Build              BuildSettings   `yaml:"build"`
+		Builder            BuilderSettings `yaml:"builder"`
+		Daemon             DaemonSettings  `yaml:"daemon"`
+	}
+
+	// LoginSettings maps onto the Login struct.
+	LoginSettings struct {
+		Registry          *string           `yaml:"registry"`
+		Username          *string           `yaml:"username"`
+		Email             *string           `yaml:"email"`
+		CredentialHelpers map[string]string `yaml:"credential_helpers"`
+		CredsStore        *string           `yaml:"creds_store"`
+	}
+
+	// BuildSettings maps onto the Build struct.
+	BuildSettings struct {
+		Repo            *string           `yaml:"repo"`
+		Tags            []string          `yaml:"tags"`
+		Dockerfile      *string           `yaml:"dockerfile"`
+		Context         *string           `yaml:"context"`
+		Target          *string           `yaml:"target"`
+		Args            map[string]string `yaml:"args"`
+		ArgsFromEnv     []string          `yaml:"args_from_env"`
+		Labels          map[string]string `yaml:"labels"`
+		AutoLabel       *bool             `yaml:"auto_label"`
+		Platform        *string           `yaml:"platform"`
+		Pull            *bool             `yaml:"pull"`
+		NoCache         *bool             `yaml:"no_cache"`
+		CacheFrom       []string          `yaml:"cache_from"`
+		CacheTo         []string          `yaml:"cache_to"`
+		Squash          *bool             `yaml:"squash"`
+		Compress        *bool             `yaml:"compress"`
+		Quiet           *bool             `yaml:"quiet"`
+		AddHost         []string          `yaml:"add_host"`
+		SecretsFromEnv  []string          `yaml:"secrets_from_env"`
+		SecretsFromFile []string          `yaml:"secrets_from_file"`
+		BuildxOptions   []string          `yaml:"buildx_options"`
+		BuildxLoad      *bool             `yaml:"buildx_load"`
+		BakeFile        *string           `yaml:"bake_file"`
+		BakeOptions     []string          `yaml:"bake_options"`
+	}
+
+	// BuilderSettings maps onto the Builder struct.
+	BuilderSettings struct {
+		Name            *string  `yaml:"name"`
+		Driver          *string  `yaml:"driver"`
+		DriverOpts      []string `yaml:"driver_opts"`
+		DaemonConfig    *string  `yaml:"daemon_config"`
+		RemoteConn      *string  `yaml:"remote_conn"`
+		BuildkitVersion *string  `yaml:"buildkit_version"`
+	}
+
+	// DaemonSettings maps onto the Daemon struct.
+	DaemonSettings struct {
+		Mirror        *string  `yaml:"mirror"`
+		StorageDriver *string  `yaml:"storage_driver"`
+		StoragePath   *string  `yaml:"storage_path"`
+		Insecure      *bool    `yaml:"insecure"`
+		Disabled      *bool    `yaml:"disabled"`
+		Debug         *bool    `yaml:"debug"`
+		Bip           *string  `yaml:"bip"`
+		DNS           []string `yaml:"dns"`
+		DNSSearch     []string `yaml:"dns_search"`
+		MTU           *string  `yaml:"mtu"`
+		IPv6          *bool    `yaml:"ipv6"`
+		RetryCount    *int     `yaml:"retry_count"`
+	}
+)
+
+// LoadSettings reads a YAML or JSON settings file. Unknown keys are rejected.
+func LoadSettings(path string) (Settings, error) {
+	var s Settings
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, fmt.Errorf("unable to read settings file %s: %s", path, err)
+	}
+	// JSON is a subset of YAML, so both formats are decoded the same way
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil && !errors.Is(err, io.EOF) {
+		return s, fmt.Errorf("invalid settings file %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// Apply copies the settings onto the plugin. isSet reports whether a flag
+// was set explicitly, in which case the flag value is kept.
+func (s Settings) Apply(p *Plugin, isSet func(flag string) bool) {
+	str := func(flag string, dst *string, src *string) {
+		if src != nil && !isSet(flag) {
+			*dst = *src
+		}
+	}
+	boolean := func(flag string, dst *bool, src *bool) {
+		if src != nil && !isSet(flag) {
+			*dst = *src
+		}
+	}
+	list := func(flag string, dst *[]string, src []string) {
+		if src != nil && !isSet(flag) {
+			*dst = src
+		}
+	}
+	duration := func(flag string, dst *time.Duration, src *time.Duration) {
+		if src != nil && !isSet(flag) {
+			*dst = *src
+		}
+	}
+
+	boolean("dry-run", &p.Dryrun, s.DryRun)
+	boolean("docker.purge", &p.Cleanup, s.Purge)
+	list("docker.purge-policy", &p.CleanupPolicy, s.PurgePolicy)
+	str("docker.purge-keep-storage", &p.CleanupKeepStorage, s.PurgeKeepStorage)
+	boolean("push-only", &p.PushOnly, s.PushOnly)
+	str("source-image", &p.SourceImage, s.SourceImage)
+	str("source-tar-path", &p.SourceTarPath, s.SourceTarPath)
+	str("tar-path", &p.TarPath, s.TarPath)
+	str("buildx-output-format", &p.BuildxOutputFormat, s.BuildxOutputFormat)
+	str("metadata-file", &p.MetadataFile, s.MetadataFile)
+	duration("timeout", &p.Timeout, s.Timeout)
+	duration("timeout-grace-period", &p.GracePeriod, s.GracePeriod)
+	boolean("strict", &p.Strict, s.Strict)
+
+	// the registry flag feeds both the login and the daemon configuration
+	str("docker.registry", &p.Login.Registry, s.Login.Registry)
+	str("docker.registry", &p.Daemon.Registry, s.Login.Registry)
+	str("docker.username", &p.Login.Username, s.Login.Username)
+	str("docker.email", &p.Login.Email, s.Login.Email)
+	if s.Login.CredentialHelpers != nil && !isSet("docker.credential-helpers") {
+		p.Login.CredHelpers = s.Login.CredentialHelpers
+	}
+	str("docker.creds-store", &p.Login.CredsStore, s.Login.CredsStore)
+
+	b := s.Build
+	str("repo", &p.Build.Repo, b.Repo)
+	list("tags", &p.Build.Tags, b.Tags)
+	str("dockerfile", &p.Build.Dockerfile, b.Dockerfile)
+	str("context", &p.Build.Context, b.Context)
+	str("target", &p.Build.Target, b.Target)
+	if b.Args != nil && !isSet("args") && !isSet("args-new") {
+		// values are taken verbatim, commas included
+		p.Build.ArgsNew = keyValues(b.Args)
+		p.Build.IsMultipleBuildArgs = true
+	}
+	list("args-from-env", &p.Build.ArgsEnv, b.ArgsFromEnv)
+	if b.Labels != nil && !isSet("custom-labels") {
+		p.Build.Labels = keyValues(b.Labels)
+	}
+	boolean("auto-label", &p.Build.AutoLabel, b.AutoLabel)
+	str("platform", &p.Build.Platform, b.Platform)
+	boolean("pull-image", &p.Build.Pull, b.Pull)
+	boolean("no-cache", &p.Build.NoCache, b.NoCache)
+	list("cache-from", &p.Build.CacheFrom, b.CacheFrom)
+	list("cache-to", &p.Build.CacheTo, b.CacheTo)
+	boolean("squash", &p.Build.Squash, b.Squash)
+	boolean("compress", &p.Build.Compress, b.Compress)
+	boolean("quiet", &p.Build.Quiet, b.Quiet)
+	list("add-host", &p.Build.AddHost, b.AddHost)
+	list("secrets-from-env", &p.Build.SecretEnvs, b.SecretsFromEnv)
+	list("secrets-from-file", &p.Build.SecretFiles, b.SecretsFromFile)
+	list("buildx-options", &p.Build.BuildxOptions, b.BuildxOptions)
+	boolean("buildx-load", &p.Build.BuildxLoad, b.BuildxLoad)
+	str("bake-file", &p.Build.BakeFile, b.BakeFile)
+	if b.BakeOptions != nil && !isSet("bake-options") {
+		p.Build.BakeOptions = strings.Join(b.BakeOptions, ";")
+	}
+
+	str("builder-name", &p.Builder.Name, s.Builder.Name)
+	str("builder-driver", &p.Builder.Driver, s.Builder.Driver)
+	list("builder-driver-opts", &p.Builder.DriverOpts, s.Builder.DriverOpts)
+	str("builder-daemon-config", &p.Builder.DaemonConfig, s.Builder.DaemonConfig)
+	str("builder-remote-conn", &p.Builder.RemoteConn, s.Builder.RemoteConn)
+	str("buildkit-version", &p.Builder.BuildkitVersion, s.Builder.BuildkitVersion)
+
+	d := s.Daemon
+	str("daemon.mirror", &p.Daemon.Mirror, d.Mirror)
+	str("daemon.storage-driver", &p.Daemon.StorageDriver, d.StorageDriver)
+	str("daemon.storage-path", &p.Daemon.StoragePath, d.StoragePath)
+	boolean("daemon.insecure", &p.Daemon.Insecure, d.Insecure)
+	boolean("daemon.off", &p.Daemon.Disabled, d.Disabled)
+	boolean("daemon.debug", &p.Daemon.Debug, d.Debug)
+	str("daemon.bip", &p.Daemon.Bip, d.Bip)
+	list("daemon.dns", &p.Daemon.DNS, d.DNS)
+	list("daemon.dns-search", &p.Daemon.DNSSearch, d.DNSSearch)
+	str("daemon.mtu", &p.Daemon.MTU, d.MTU)
+	boolean("daemon.ipv6", &p.Daemon.IPv6, d.IPv6)
+	if d.RetryCount != nil && !isSet("daemon.retry-count") {
+		p.Daemon.RetryCount = *d.RetryCount
+	}
+}
+
+// helper function that converts a map to a list of key=value pairs sorted by
+// key, so the generated command line is stable.
+func keyValues(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for k, v := range m {
+		out = append(out, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(out)
+	return out
+}
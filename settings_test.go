@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadSettings(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "settings.yml")
+	os.WriteFile(yamlPath, []byte(`
+dry_run: true
+timeout: 45m
+build:
+  repo: octocat/app
+  tags: [latest, "1.0"]
+  args:
+    VERSION: "1.0"
+    LIST: a,b
+  labels:
+    team: platform
+builder:
+  driver_opts:
+    - image=moby/buildkit:v0.12.0
+daemon:
+  retry_count: 3
+`), 0600)
+
+	jsonPath := filepath.Join(dir, "settings.json")
+	os.WriteFile(jsonPath, []byte(`{"dry_run": true, "timeout": "45m", "build": {"repo": "octocat/app", "tags": ["latest", "1.0"]}}`), 0600)
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		s, err := LoadSettings(path)
+		if err != nil {
+			t.Fatalf("unexpected error loading %s: %s", path, err)
+		}
+		if s.DryRun == nil || !*s.DryRun {
+			t.Errorf("%s: Got dry_run %v, want true", path, s.DryRun)
+		}
+		if s.Timeout == nil || *s.Timeout != 45*time.Minute {
+			t.Errorf("%s: Got timeout %v, want 45m", path, s.Timeout)
+		}
+		if !reflect.DeepEqual(s.Build.Tags, []string{"latest", "1.0"}) {
+			t.Errorf("%s: Got tags %v", path, s.Build.Tags)
+		}
+	}
+}
+
+func TestLoadSettingsUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.yml")
+	os.WriteFile(path, []byte("build:\n  repo: octocat/app\n  tag: latest\n"), 0600)
+
+	_, err := LoadSettings(path)
+	if err == nil || !strings.Contains(err.Error(), "field tag not found") {
+		t.Errorf("Got error %v, want unknown key error", err)
+	}
+}
+
+func TestSettingsApply(t *testing.T) {
+	repo := "octocat/app"
+	dryRun := true
+	retries := 3
+	s := Settings{
+		DryRun: &dryRun,
+		Build: BuildSettings{
+			Repo: &repo,
+			Tags: []string{"1.0"},
+			Args: map[string]string{"VERSION": "1.0", "LIST": "a,b"},
+		},
+		Daemon: DaemonSettings{RetryCount: &retries},
+	}
+
+	p := Plugin{Build: Build{Tags: []string{"latest"}, Repo: "from/env"}}
+	set := map[string]bool{"repo": true}
+	s.Apply(&p, func(flag string) bool { return set[flag] })
+
+	if p.Build.Repo != "from/env" {
+		t.Errorf("Got repo %q, want flag value to take precedence", p.Build.Repo)
+	}
+	if !reflect.DeepEqual(p.Build.Tags, []string{"1.0"}) {
+		t.Errorf("Got tags %v, want [1.0]", p.Build.Tags)
+	}
+	if !p.Dryrun || p.Daemon.RetryCount != 3 {
+		t.Errorf("Got dry run %v and retry count %d", p.Dryrun, p.Daemon.RetryCount)
+	}
+	if !p.Build.IsMultipleBuildArgs || !reflect.DeepEqual(p.Build.ArgsNew, []string{"LIST=a,b", "VERSION=1.0"}) {
+		t.Errorf("Got build args %v", p.Build.ArgsNew)
+	}
+}
@@ -2,7 +2,10 @@ package docker
 
 import (
 	"fmt"
+	"path"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-semver/semver"
 )
@@ -10,12 +13,24 @@ import (
 // DefaultTagSuffix returns a set of default suggested tags
 // based on the commit ref with an attached suffix.
 func DefaultTagSuffix(ref, suffix string) ([]string, error) {
-	tags, err := DefaultTags(ref)
+	return SemverTagSuffix(ref, suffix, DefaultSemverPolicy())
+}
+
+// SemverTagSuffix returns the tags for the commit ref according to the
+// policy with an attached suffix.
+func SemverTagSuffix(ref, suffix string, policy SemverPolicy) ([]string, error) {
+	tags, err := SemverTags(ref, policy)
 	if err != nil {
 		return nil, err
 	}
+	return applySuffix(tags, suffix), nil
+}
+
+// helper function that attaches the suffix to every tag, replacing latest
+// with the suffix itself.
+func applySuffix(tags []string, suffix string) []string {
 	if len(suffix) == 0 {
-		return tags, nil
+		return tags
 	}
 	for i, tag := range tags {
 		if tag == "latest" {
@@ -24,7 +39,7 @@ func DefaultTagSuffix(ref, suffix string) ([]string, error) {
 			tags[i] = fmt.Sprintf("%s-%s", tag, suffix)
 		}
 	}
-	return tags, nil
+	return tags
 }
 
 func splitOff(input string, delim string) string {
@@ -40,6 +55,36 @@ func splitOff(input string, delim string) string {
 // DefaultTags returns a set of default suggested tags based on
 // the commit ref.
 func DefaultTags(ref string) ([]string, error) {
+	return SemverTags(ref, DefaultSemverPolicy())
+}
+
+// Build metadata handling selected with PLUGIN_SEMVER_METADATA.
+const (
+	SemverMetadataKeep   = "keep"   // treat versions with metadata like pre-releases
+	SemverMetadataIgnore = "ignore" // drop the metadata
+	SemverMetadataTag    = "tag"    // drop the metadata and add a full version tag with the metadata appended
+)
+
+// SemverPolicy controls the tags generated for semver tag refs.
+type SemverPolicy struct {
+	Components []string // Version components to emit: major, minor and patch
+	ZeroMajor  bool     // Emit the major tag for 0.x versions
+	Channels   bool     // Add moving channel tags for pre-releases, e.g. 2.0-rc and rc
+	Latest     bool     // Add latest when the version is the highest stable version
+	Metadata   string   // Build metadata handling: keep, ignore or tag
+	Versions   []string // Existing version tags, used to find the highest stable version
+}
+
+// DefaultSemverPolicy returns the policy used by DefaultTags.
+func DefaultSemverPolicy() SemverPolicy {
+	return SemverPolicy{
+		Components: []string{"major", "minor", "patch"},
+		Metadata:   SemverMetadataKeep,
+	}
+}
+
+// SemverTags returns the tags for the commit ref according to the policy.
+func SemverTags(ref string, policy SemverPolicy) ([]string, error) {
 	if !strings.HasPrefix(ref, "refs/tags/") {
 		return []string{"latest"}, nil
 	}
@@ -48,27 +93,66 @@ func DefaultTags(ref string) ([]string, error) {
 	if err != nil {
 		return []string{"latest"}, err
 	}
-	if version.PreRelease != "" || version.Metadata != "" {
-		return []string{
-			version.String(),
-		}, nil
+	metadata := string(version.Metadata)
+	if policy.Metadata == SemverMetadataIgnore || policy.Metadata == SemverMetadataTag {
+		version.Metadata = ""
 	}
 
-	v = stripTagPrefix(ref)
 	v = splitOff(splitOff(v, "+"), "-")
 	dotParts := strings.SplitN(v, ".", 3)
+	major := fmt.Sprintf("%0*d", len(dotParts[0]), version.Major)
+	minor := fmt.Sprintf("%s.%0*d", major, len(dotParts[1]), version.Minor)
+	patch := fmt.Sprintf("%s.%0*d", minor, len(dotParts[2]), version.Patch)
 
-	if version.Major == 0 {
-		return []string{
-			fmt.Sprintf("%0*d.%0*d", len(dotParts[0]), version.Major, len(dotParts[1]), version.Minor),
-			fmt.Sprintf("%0*d.%0*d.%0*d", len(dotParts[0]), version.Major, len(dotParts[1]), version.Minor, len(dotParts[2]), version.Patch),
-		}, nil
+	var tags []string
+	if version.PreRelease != "" || version.Metadata != "" {
+		tags = append(tags, version.String())
+		if channel := preReleaseChannel(string(version.PreRelease)); policy.Channels && channel != "" {
+			tags = append(tags, fmt.Sprintf("%s-%s", minor, channel), channel)
+		}
+	} else {
+		if contains(policy.Components, "major") && (version.Major != 0 || policy.ZeroMajor) {
+			tags = append(tags, major)
+		}
+		if contains(policy.Components, "minor") {
+			tags = append(tags, minor)
+		}
+		if contains(policy.Components, "patch") {
+			tags = append(tags, patch)
+		}
+	}
+
+	if policy.Metadata == SemverMetadataTag && metadata != "" {
+		// + is not allowed in docker tags
+		tags = append(tags, fmt.Sprintf("%s_%s", version.String(), metadata))
+	}
+	if policy.Latest && version.PreRelease == "" && isHighestVersion(*version, policy.Versions) {
+		tags = append(tags, "latest")
 	}
-	return []string{
-		fmt.Sprintf("%0*d", len(dotParts[0]), version.Major),
-		fmt.Sprintf("%0*d.%0*d", len(dotParts[0]), version.Major, len(dotParts[1]), version.Minor),
-		fmt.Sprintf("%0*d.%0*d.%0*d", len(dotParts[0]), version.Major, len(dotParts[1]), version.Minor, len(dotParts[2]), version.Patch),
-	}, nil
+	return tags, nil
+}
+
+// helper function that returns the channel of a pre-release, the leading
+// letters of its first identifier, e.g. rc for rc.1 and beta for beta2.
+func preReleaseChannel(preRelease string) string {
+	channel := strings.SplitN(preRelease, ".", 2)[0]
+	channel = strings.TrimRight(channel, "0123456789")
+	return sanitizeTag(strings.ToLower(channel))
+}
+
+// helper function that reports whether no stable version in versions is
+// higher than version. Entries that are not valid versions are ignored.
+func isHighestVersion(version semver.Version, versions []string) bool {
+	for _, s := range versions {
+		other, err := semver.NewVersion(stripTagPrefix(s))
+		if err != nil || other.PreRelease != "" {
+			continue
+		}
+		if version.LessThan(*other) {
+			return false
+		}
+	}
+	return true
 }
 
 // UseDefaultTag for keep only default branch for latest tag
@@ -91,3 +175,159 @@ func stripTagPrefix(ref string) string {
 	ref = strings.TrimPrefix(ref, "v")
 	return ref
 }
+
+// Tag strategies selected with PLUGIN_TAG_STRATEGY when automatic tagging is
+// enabled.
+const (
+	TagStrategyDefault = "default" // latest on the default branch, semver on tags, skip everything else
+	TagStrategyBranch  = "branch"  // additionally tag feature branches and pull requests
+)
+
+// pullRequestRef matches the refs used for pull and merge requests.
+var pullRequestRef = regexp.MustCompile(`^refs/(?:pull|merge-requests)/(\d+)/`)
+
+// BranchTags returns the tags for the branch strategy. Tag refs and the
+// default branch are tagged by generate.
+// Pull requests produce pr-<number> and must not be pushed, which is
+// reported by push. Other branches are tagged with their sanitized name.
+func BranchTags(ref, defaultBranch, pullRequest, suffix string, generate TagGenerator) (tags []string, push bool, err error) {
+	if pullRequest == "" {
+		if m := pullRequestRef.FindStringSubmatch(ref); m != nil {
+			pullRequest = m[1]
+		}
+	}
+	if pullRequest != "" {
+		return []string{withSuffix("pr-"+pullRequest, suffix)}, false, nil
+	}
+	if UseDefaultTag(ref, defaultBranch) {
+		tags, err := generate(ref, suffix)
+		return tags, true, err
+	}
+	branch := sanitizeTag(stripHeadPrefix(ref))
+	if branch == "" || branch == "latest" {
+		return nil, false, fmt.Errorf("cannot derive a tag from ref %s", ref)
+	}
+	return []string{withSuffix(branch, suffix)}, true, nil
+}
+
+// helper function to append a suffix to a tag.
+func withSuffix(tag, suffix string) string {
+	if suffix == "" {
+		return tag
+	}
+	return fmt.Sprintf("%s-%s", tag, suffix)
+}
+
+// componentSeparators separate the component prefix from the version in a
+// component tag, e.g. billing/v1.4.2 or billing-1.4.2.
+const componentSeparators = "/-_@"
+
+// componentVersion matches the version part of a component tag.
+var componentVersion = regexp.MustCompile(`^v?[0-9]`)
+
+// ComponentRef strips the component prefix from a tag ref, so that
+// refs/tags/billing/v1.4.2 with prefix billing/ becomes refs/tags/v1.4.2.
+// The prefix must end with a separator or a v, as in billing/ or billing-v,
+// or be followed by one of / - _ @ in the tag, and a version must follow, so
+// billing matches billing/v1.4.2 but not billing-x/v1.4.2. The prefix can be
+// a glob such as services/*/, matched with path.Match. It reports false for
+// tag refs that belong to another component. Branch refs, and all refs when
+// prefix is empty, are returned unchanged.
+func ComponentRef(ref, prefix string) (string, bool) {
+	if prefix == "" || !strings.HasPrefix(ref, "refs/tags/") {
+		return ref, true
+	}
+	tag := strings.TrimPrefix(ref, "refs/tags/")
+	separated := componentSeparated(prefix)
+	// the longest matching prefix wins when a glob matches several
+	for i := len(tag); i > 0; i-- {
+		if ok, err := path.Match(prefix, tag[:i]); err != nil || !ok {
+			continue
+		}
+		version := tag[i:]
+		if !separated {
+			if version == "" || strings.IndexByte(componentSeparators, version[0]) < 0 {
+				continue
+			}
+			version = version[1:]
+		}
+		if componentVersion.MatchString(version) {
+			return "refs/tags/" + version, true
+		}
+	}
+	return ref, false
+}
+
+// helper function that reports whether a component prefix ends with a
+// separator, optionally followed by a v.
+func componentSeparated(prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "v")
+	return prefix != "" && strings.IndexByte(componentSeparators, prefix[len(prefix)-1]) >= 0
+}
+
+// Tag generators selected with PLUGIN_AUTO_TAG_GENERATOR.
+const (
+	TagGeneratorSemver   = "semver"   // semver tags from the git tag, see SemverTags
+	TagGeneratorCalVer   = "calver"   // calendar version from the commit timestamp
+	TagGeneratorDescribe = "describe" // git describe output of the checkout
+)
+
+// TagGenerator returns the tags for a commit ref with an attached suffix.
+type TagGenerator func(ref, suffix string) ([]string, error)
+
+// calVerToken matches the calendar versioning format tokens.
+var calVerToken = regexp.MustCompile(`YYYY|YY|0Y|MM|0M|WW|0W|DD|0D|MICRO`)
+
+// CalVer formats t according to a calendar versioning format such as
+// YYYY.MM.DD or YYYY.0M.MICRO. The tokens follow calver.org: YYYY, YY and 0Y
+// for the year, MM and 0M for the month, WW and 0W for the ISO week, DD and
+// 0D for the day, and MICRO for the build number.
+func CalVer(format string, t time.Time, micro string) (string, error) {
+	if !calVerToken.MatchString(format) {
+		return "", fmt.Errorf("calver format %q does not contain any date token", format)
+	}
+	if micro == "" {
+		micro = "0"
+	}
+	t = t.UTC()
+	_, week := t.ISOWeek()
+	version := calVerToken.ReplaceAllStringFunc(format, func(token string) string {
+		switch token {
+		case "YYYY":
+			return fmt.Sprintf("%d", t.Year())
+		case "YY":
+			return fmt.Sprintf("%d", t.Year()-2000)
+		case "0Y":
+			return fmt.Sprintf("%02d", t.Year()-2000)
+		case "MM":
+			return fmt.Sprintf("%d", t.Month())
+		case "0M":
+			return fmt.Sprintf("%02d", t.Month())
+		case "WW":
+			return fmt.Sprintf("%d", week)
+		case "0W":
+			return fmt.Sprintf("%02d", week)
+		case "DD":
+			return fmt.Sprintf("%d", t.Day())
+		case "0D":
+			return fmt.Sprintf("%02d", t.Day())
+		}
+		return micro
+	})
+	return version, nil
+}
+
+// VersionTags returns the tags for a version computed by the calver or
+// describe generators. Builds of a branch are also tagged latest, builds of
+// a git tag are not, as with DefaultTags.
+func VersionTags(ref, version, suffix string) ([]string, error) {
+	tag := sanitizeTag(version)
+	if tag == "" {
+		return nil, fmt.Errorf("cannot derive a tag from version %q", version)
+	}
+	tags := []string{tag}
+	if !strings.HasPrefix(ref, "refs/tags/") {
+		tags = append(tags, "latest")
+	}
+	return applySuffix(tags, suffix), nil
+}
@@ -3,6 +3,7 @@ package docker
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_stripTagPrefix(t *testing.T) {
@@ -195,3 +196,207 @@ func TestUseDefaultTag(t *testing.T) {
 		}
 	}
 }
+
+func TestBranchTags(t *testing.T) {
+	var tests = []struct {
+		Ref         string
+		PullRequest string
+		Suffix      string
+		Tags        []string
+		Push        bool
+	}{
+		{Ref: "refs/heads/main", Tags: []string{"latest"}, Push: true},
+		{Ref: "refs/heads/main", Suffix: "linux-amd64", Tags: []string{"linux-amd64"}, Push: true},
+		{Ref: "refs/tags/v1.2.3", Tags: []string{"1", "1.2", "1.2.3"}, Push: true},
+		{Ref: "refs/heads/feature/login", Tags: []string{"feature-login"}, Push: true},
+		{Ref: "refs/heads/feature/login", Suffix: "nanoserver", Tags: []string{"feature-login-nanoserver"}, Push: true},
+		{Ref: "refs/pull/42/head", PullRequest: "42", Tags: []string{"pr-42"}},
+		{Ref: "refs/pull/7/merge", Tags: []string{"pr-7"}},
+		{Ref: "refs/merge-requests/9/head", Suffix: "arm64", Tags: []string{"pr-9-arm64"}},
+	}
+
+	for _, test := range tests {
+		tags, push, err := BranchTags(test.Ref, "main", test.PullRequest, test.Suffix, DefaultTagSuffix)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", test.Ref, err)
+			continue
+		}
+		if !reflect.DeepEqual(tags, test.Tags) || push != test.Push {
+			t.Errorf("Got tags %v push %v for %s, want %v push %v", tags, push, test.Ref, test.Tags, test.Push)
+		}
+	}
+
+	if _, _, err := BranchTags("refs/heads/latest", "main", "", "", DefaultTagSuffix); err == nil {
+		t.Error("Expect error for branch named latest")
+	}
+}
+
+func TestComponentRef(t *testing.T) {
+	var tests = []struct {
+		Ref    string
+		Prefix string
+		After  string
+		Match  bool
+	}{
+		{"refs/tags/v1.4.2", "", "refs/tags/v1.4.2", true},
+		{"refs/tags/billing/v1.4.2", "billing/", "refs/tags/v1.4.2", true},
+		{"refs/tags/billing-1.4.2", "billing-", "refs/tags/1.4.2", true},
+		{"refs/tags/payments/v2.0.0", "billing/", "refs/tags/payments/v2.0.0", false},
+		{"refs/tags/v1.4.2", "billing/", "refs/tags/v1.4.2", false},
+		{"refs/heads/main", "billing/", "refs/heads/main", true},
+		{"refs/tags/billing/v1.4.2", "billing", "refs/tags/v1.4.2", true},
+		{"refs/tags/billing-v1.4.2", "billing", "refs/tags/v1.4.2", true},
+		{"refs/tags/billing-v1.4.2", "billing-v", "refs/tags/1.4.2", true},
+		{"refs/tags/billing-x/v1.2.3", "billing", "refs/tags/billing-x/v1.2.3", false},
+		{"refs/tags/billing-x/v1.2.3", "billing-", "refs/tags/billing-x/v1.2.3", false},
+		{"refs/tags/billing2/v1.2.3", "billing", "refs/tags/billing2/v1.2.3", false},
+		{"refs/tags/dev1.2.3", "dev", "refs/tags/dev1.2.3", false},
+		{"refs/tags/services/billing/v1.4.2", "services/*/", "refs/tags/v1.4.2", true},
+		{"refs/tags/billing-api/v1.4.2", "billing-*", "refs/tags/v1.4.2", true},
+		{"refs/tags/payments/v2.0.0", "billing-*", "refs/tags/payments/v2.0.0", false},
+	}
+
+	for _, test := range tests {
+		got, match := ComponentRef(test.Ref, test.Prefix)
+		if got != test.After || match != test.Match {
+			t.Errorf("Got %s %v for %s, want %s %v", got, match, test.Ref, test.After, test.Match)
+		}
+	}
+
+	ref, _ := ComponentRef("refs/tags/billing/v1.4.2", "billing/")
+	tags, err := DefaultTags(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1", "1.4", "1.4.2"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("Got tag %v, want %v", tags, want)
+	}
+}
+
+func TestSemverTags(t *testing.T) {
+	var tests = []struct {
+		Ref    string
+		Policy func(*SemverPolicy)
+		After  []string
+	}{
+		{
+			Ref:    "refs/tags/v1.2.3",
+			Policy: func(p *SemverPolicy) { p.Components = []string{"minor", "patch"} },
+			After:  []string{"1.2", "1.2.3"},
+		},
+		{
+			Ref:    "refs/tags/v0.9.1",
+			Policy: func(p *SemverPolicy) { p.ZeroMajor = true },
+			After:  []string{"0", "0.9", "0.9.1"},
+		},
+		{
+			Ref:    "refs/tags/v2.0.0-rc.1",
+			Policy: func(p *SemverPolicy) { p.Channels = true },
+			After:  []string{"2.0.0-rc.1", "2.0-rc", "rc"},
+		},
+		{
+			Ref:    "refs/tags/v2.0.0-beta2",
+			Policy: func(p *SemverPolicy) { p.Channels = true; p.Latest = true },
+			After:  []string{"2.0.0-beta2", "2.0-beta", "beta"},
+		},
+		{
+			Ref:    "refs/tags/v1.2.3+build.5",
+			Policy: func(p *SemverPolicy) {},
+			After:  []string{"1.2.3+build.5"},
+		},
+		{
+			Ref:    "refs/tags/v1.2.3+build.5",
+			Policy: func(p *SemverPolicy) { p.Metadata = SemverMetadataIgnore },
+			After:  []string{"1", "1.2", "1.2.3"},
+		},
+		{
+			Ref:    "refs/tags/v1.2.3+build.5",
+			Policy: func(p *SemverPolicy) { p.Metadata = SemverMetadataTag },
+			After:  []string{"1", "1.2", "1.2.3", "1.2.3_build.5"},
+		},
+		{
+			Ref: "refs/tags/v1.2.3",
+			Policy: func(p *SemverPolicy) {
+				p.Latest = true
+				p.Versions = []string{"refs/tags/v1.2.2", "refs/tags/v1.3.0-rc.1", "not-a-version"}
+			},
+			After: []string{"1", "1.2", "1.2.3", "latest"},
+		},
+		{
+			Ref: "refs/tags/v1.2.3",
+			Policy: func(p *SemverPolicy) {
+				p.Latest = true
+				p.Versions = []string{"refs/tags/v2.0.0"}
+			},
+			After: []string{"1", "1.2", "1.2.3"},
+		},
+	}
+
+	for _, test := range tests {
+		policy := DefaultSemverPolicy()
+		test.Policy(&policy)
+		got, err := SemverTags(test.Ref, policy)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.After) {
+			t.Errorf("Got tag %v for %s, want %v", got, test.Ref, test.After)
+		}
+	}
+}
+
+func TestCalVer(t *testing.T) {
+	ts := time.Date(2024, 3, 5, 23, 30, 0, 0, time.UTC)
+
+	var tests = []struct {
+		Format string
+		Micro  string
+		After  string
+	}{
+		{"YYYY.MM.DD", "", "2024.3.5"},
+		{"YYYY.0M.0D", "", "2024.03.05"},
+		{"YYYY.0M.MICRO", "42", "2024.03.42"},
+		{"YY.0W", "", "24.10"},
+		{"0Y.MM.MICRO", "", "24.3.0"},
+	}
+
+	for _, test := range tests {
+		got, err := CalVer(test.Format, ts, test.Micro)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != test.After {
+			t.Errorf("Got version %s for %s, want %s", got, test.Format, test.After)
+		}
+	}
+
+	if _, err := CalVer("release", ts, ""); err == nil {
+		t.Error("Expect error for format without tokens")
+	}
+}
+
+func TestVersionTags(t *testing.T) {
+	var tests = []struct {
+		Ref     string
+		Version string
+		Suffix  string
+		After   []string
+	}{
+		{"refs/heads/main", "2024.03.05", "", []string{"2024.03.05", "latest"}},
+		{"refs/heads/main", "2024.03.05", "linux-amd64", []string{"2024.03.05-linux-amd64", "linux-amd64"}},
+		{"refs/tags/v1.2.3", "v1.2.3-14-gabc1234", "", []string{"v1.2.3-14-gabc1234"}},
+	}
+
+	for _, test := range tests {
+		got, err := VersionTags(test.Ref, test.Version, test.Suffix)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.After) {
+			t.Errorf("Got tag %v, want %v", got, test.After)
+		}
+	}
+}
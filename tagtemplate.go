@@ -0,0 +1,126 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// maxTagLength is the longest tag accepted by the registry.
+const maxTagLength = 128
+
+// invalidTagChars matches characters that are not allowed in a Docker tag.
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// TagContext is the data available to tag templates.
+type TagContext struct {
+	SHA         string    // Full commit SHA
+	ShortSHA    string    // First 8 characters of the commit SHA
+	Ref         string    // Git ref, e.g. refs/heads/main
+	Branch      string    // Branch name
+	Tag         string    // Git tag, empty unless the build was triggered by a tag
+	BuildNumber string    // Build number
+	Event       string    // Build event, e.g. push, tag or pull_request
+	Time        time.Time // Build timestamp in UTC
+}
+
+// NewTagContext returns the template data for a commit. The branch and tag
+// are derived from the ref when they are not set explicitly.
+func NewTagContext(sha, ref, branch, tag, buildNumber, event string, created time.Time) TagContext {
+	short := sha
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	if branch == "" && strings.HasPrefix(ref, "refs/heads/") {
+		branch = stripHeadPrefix(ref)
+	}
+	if tag == "" && strings.HasPrefix(ref, "refs/tags/") {
+		tag = strings.TrimPrefix(ref, "refs/tags/")
+	}
+	if created.IsZero() {
+		created = time.Now()
+	}
+	return TagContext{
+		SHA:         sha,
+		ShortSHA:    short,
+		Ref:         ref,
+		Branch:      branch,
+		Tag:         tag,
+		BuildNumber: buildNumber,
+		Event:       event,
+		Time:        created.UTC(),
+	}
+}
+
+// RenderTags renders the tags that contain template expressions and sanitizes
+// the result into valid Docker tag syntax. Literal tags are returned as is.
+// Tags that render empty are dropped, so a tag can be made conditional, and
+// duplicates are removed.
+func RenderTags(tags []string, data TagContext) ([]string, error) {
+	var out []string
+	for _, tag := range tags {
+		if strings.Contains(tag, "{{") {
+			tmpl, err := template.New("tag").Funcs(tagFuncs).Option("missingkey=error").Parse(tag)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tag template %q: %s", tag, err)
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, data); err != nil {
+				return nil, fmt.Errorf("unable to render tag template %q: %s", tag, err)
+			}
+			tag = sanitizeTag(buf.String())
+		}
+		if tag == "" || contains(out, tag) {
+			continue
+		}
+		out = append(out, tag)
+	}
+	return out, nil
+}
+
+// sanitizeTag converts s into a valid Docker tag: invalid characters are
+// replaced with a dash, leading dots and dashes are removed and the result is
+// truncated to the maximum tag length.
+func sanitizeTag(s string) string {
+	s = invalidTagChars.ReplaceAllString(strings.TrimSpace(s), "-")
+	s = strings.TrimLeft(s, ".-")
+	if len(s) > maxTagLength {
+		s = s[:maxTagLength]
+	}
+	return strings.TrimRight(s, ".-")
+}
+
+// tagFuncs are the helpers available to tag templates. Argument order
+// follows sprig, so the piped value is always the last argument.
+var tagFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trunc":      trunc,
+	"default": func(def string, v string) string {
+		if v == "" {
+			return def
+		}
+		return v
+	},
+	"date":     func(layout string, t time.Time) string { return t.Format(layout) },
+	"sanitize": sanitizeTag,
+}
+
+// helper function that truncates s to n characters, or drops all but the
+// last -n characters when n is negative.
+func trunc(n int, s string) string {
+	switch {
+	case n < 0 && len(s)+n > 0:
+		return s[len(s)+n:]
+	case n >= 0 && len(s) > n:
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderTags(t *testing.T) {
+	data := NewTagContext(
+		"9f2a8e1c5b7d3a6f0e4c2b1a9d8e7f6a5b4c3d2e",
+		"refs/heads/feature/JIRA-123_Login",
+		"",
+		"",
+		"42",
+		"push",
+		time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC),
+	)
+
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		{
+			tags: []string{"latest", "1.0"},
+			want: []string{"latest", "1.0"},
+		},
+		{
+			tags: []string{"{{.Branch | sanitize}}-{{.ShortSHA}}"},
+			want: []string{"feature-JIRA-123_Login-9f2a8e1c"},
+		},
+		{
+			tags: []string{"{{.Branch | lower | replace \"/\" \"_\" | trunc 12}}"},
+			want: []string{"feature_jira"},
+		},
+		{
+			tags: []string{"build-{{.BuildNumber}}-{{date \"20060102\" .Time}}"},
+			want: []string{"build-42-20240305"},
+		},
+		{
+			tags: []string{"{{.Branch}}"},
+			want: []string{"feature-JIRA-123_Login"},
+		},
+		{
+			tags: []string{"{{.Tag}}", "{{.Tag | default \"edge\"}}", "edge"},
+			want: []string{"edge"},
+		},
+		{
+			tags: []string{"{{.SHA | trunc -4}}"},
+			want: []string{"3d2e"},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := RenderTags(tt.tags, data)
+		if err != nil {
+			t.Errorf("RenderTags(%v) unexpected error: %s", tt.tags, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RenderTags(%v) = %v, want %v", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestRenderTagsErrors(t *testing.T) {
+	data := NewTagContext("abc", "refs/tags/v1.0.0", "", "", "", "tag", time.Time{})
+	if data.Tag != "v1.0.0" {
+		t.Errorf("Got tag %q, want v1.0.0", data.Tag)
+	}
+	for _, tag := range []string{"{{.Branch", "{{.Unknown}}", "{{nosuchfunc .Branch}}"} {
+		if _, err := RenderTags([]string{tag}, data); err == nil {
+			t.Errorf("RenderTags(%q) expected error", tag)
+		}
+	}
+}
+
+func TestSanitizeTag(t *testing.T) {
+	tests := map[string]string{
+		"feature/login":          "feature-login",
+		"..hidden":               "hidden",
+		"-dash-":                 "dash",
+		"v1.2.3+build.4":         "v1.2.3-build.4",
+		"  spaced out  ":         "spaced-out",
+		strings.Repeat("a", 200): strings.Repeat("a", maxTagLength),
+	}
+	for in, want := range tests {
+		if got := sanitizeTag(in); got != want {
+			t.Errorf("sanitizeTag(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity of a configuration diagnostic.
+type Severity string
+
+// Diagnostic severities. Warnings are reported and ignored unless the plugin
+// runs in strict mode, where they fail the step like errors.
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+type (
+	// Diagnostic describes a single configuration problem.
+	Diagnostic struct {
+		Severity Severity // Error or warning
+		Message  string   // Description of the problem
+		EnvVars  []string // Environment variables involved
+	}
+
+	// ValidationError is returned by Validate and holds every problem found.
+	ValidationError struct {
+		Diagnostics []Diagnostic
+	}
+)
+
+func (d Diagnostic) String() string {
+	if len(d.EnvVars) == 0 {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s (%s)", d.Severity, d.Message, strings.Join(d.EnvVars, ", "))
+}
+
+func (e *ValidationError) Error() string {
+	lines := []string{fmt.Sprintf("invalid plugin configuration, %d problem(s) found:", len(e.Diagnostics))}
+	for _, d := range e.Diagnostics {
+		lines = append(lines, "  - "+d.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks the plugin configuration for conflicting or ignored
+// settings before anything is executed. All problems are returned at once in
+// a *ValidationError. Warnings are printed and only fail validation in strict
+// mode.
+func (p Plugin) Validate() error {
+	var failed []Diagnostic
+	for _, d := range p.Diagnostics() {
+		if d.Severity == SeverityWarning && !p.Strict {
+			fmt.Printf("Warning: %s\n", strings.TrimPrefix(d.String(), "warning: "))
+			continue
+		}
+		failed = append(failed, d)
+	}
+	if len(failed) > 0 {
+		return &ValidationError{Diagnostics: failed}
+	}
+	return nil
+}
+
+// Diagnostics returns every problem found in the plugin configuration.
+func (p Plugin) Diagnostics() []Diagnostic {
+	var diags []Diagnostic
+	errorf := func(vars []string, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{Severity: SeverityError, Message: fmt.Sprintf(format, args...), EnvVars: vars})
+	}
+	warnf := func(vars []string, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{Severity: SeverityWarning, Message: fmt.Sprintf(format, args...), EnvVars: vars})
+	}
+
+	bake := p.Build.BakeFile != ""
+
+	// mode conflicts
+	if bake && p.PushOnly {
+		errorf([]string{"PLUGIN_BAKE_FILE", "PLUGIN_PUSH_ONLY"}, "Bake mode and Push-only mode cannot be used together")
+	}
+	if !p.PushOnly && p.SourceImage != "" {
+		warnf([]string{"PLUGIN_SOURCE_IMAGE", "PLUGIN_PUSH_ONLY"}, "source image is only used in Push-only mode and is ignored")
+	}
+	if !p.PushOnly && p.SourceTarPath != "" {
+		warnf([]string{"PLUGIN_SOURCE_TAR_PATH", "PLUGIN_PUSH_ONLY"}, "source tar path is only used in Push-only mode and is ignored")
+	}
+
+	// tar output
+	switch p.BuildxOutputFormat {
+	case "", "docker", "oci":
+	default:
+		errorf([]string{"PLUGIN_BUILDX_OUTPUT_FORMAT"}, "unsupported buildx output format %q, expected docker or oci", p.BuildxOutputFormat)
+	}
+	if p.BuildxOutputFormat != "" && p.TarPath == "" {
+		warnf([]string{"PLUGIN_BUILDX_OUTPUT_FORMAT", "PLUGIN_TAR_PATH"}, "buildx output format is ignored without a tar path")
+	} else if p.BuildxOutputFormat != "" && !p.Dryrun && !bake && !p.PushOnly {
+		warnf([]string{"PLUGIN_BUILDX_OUTPUT_FORMAT", "PLUGIN_DRY_RUN"}, "buildx output format is only used when dry run is enabled and is ignored")
+	}
+	if p.TarPath != "" && bake {
+		warnf([]string{"PLUGIN_TAR_PATH", "PLUGIN_BAKE_FILE"}, "tar path is ignored in Bake mode, define outputs in the bake file")
+	} else if p.TarPath != "" && p.PushOnly {
+		warnf([]string{"PLUGIN_TAR_PATH", "PLUGIN_PUSH_ONLY"}, "tar path is ignored in Push-only mode, use the source tar path to push an image from a tar file")
+	} else if p.TarPath != "" && !p.Dryrun {
+		warnf([]string{"PLUGIN_TAR_PATH", "PLUGIN_DRY_RUN"}, "tar path is only written when dry run is enabled and is ignored")
+	}
+
+	// Bake mode ignores the classic cache settings
+	if bake && (len(p.Build.CacheFrom) > 0 || len(p.Build.CacheTo) > 0 || p.Build.NoCache) {
+		warnf([]string{"PLUGIN_CACHE_FROM", "PLUGIN_CACHE_TO", "PLUGIN_NO_CACHE", "PLUGIN_BAKE_FILE"}, "cache settings are ignored in Bake mode, define cache in the bake file")
+	}
+
+	// credentials
+	if p.Login.Password != "" && p.Login.Username == "" {
+		errorf([]string{"PLUGIN_USERNAME", "PLUGIN_PASSWORD"}, "registry password is set without a username")
+	}
+	if p.BaseImageRegistry != "" && (p.BaseImageUsername == "" || p.BaseImagePassword == "") {
+		warnf([]string{"PLUGIN_BASE_IMAGE_REGISTRY", "PLUGIN_BASE_IMAGE_USERNAME", "PLUGIN_BASE_IMAGE_PASSWORD"}, "the base image connector requires both a username and a password")
+	}
+
+	for i, r := range p.PullRegistries {
+		if r.Registry == "" {
+			errorf([]string{"PLUGIN_PULL_REGISTRIES"}, "pull registry %d has no registry address", i+1)
+		} else if r.Username == "" || r.Password == "" {
+			errorf([]string{"PLUGIN_PULL_REGISTRIES"}, "pull registry %s requires both a username and a password", r.Registry)
+		}
+	}
+	for _, helper := range p.missingCredHelpers() {
+		warnf([]string{"PLUGIN_CREDENTIAL_HELPERS", "PLUGIN_CREDS_STORE"}, "credential helper %s%s is not installed", credHelperPrefix, helper)
+	}
+
+	// destinations
+	if len(p.Destinations) > 0 && bake {
+		errorf([]string{"PLUGIN_DESTINATIONS", "PLUGIN_BAKE_FILE"}, "destinations cannot be used in Bake mode, define the tags in the bake file")
+	}
+	for i, d := range p.Destinations {
+		if d.Repo == "" {
+			errorf([]string{"PLUGIN_DESTINATIONS"}, "destination %d has no repository", i+1)
+		}
+		if d.Password != "" && d.Username == "" {
+			errorf([]string{"PLUGIN_DESTINATIONS"}, "destination %s has a password without a username", d.Repo)
+		}
+	}
+
+	// cleanup
+	if p.Cleanup {
+		policies, err := p.cleanupPolicies()
+		if err != nil {
+			errorf([]string{"PLUGIN_PURGE_POLICY"}, "%s", err)
+		} else if p.CleanupKeepStorage != "" && !contains(policies, cleanupCache) {
+			warnf([]string{"PLUGIN_PURGE_KEEP_STORAGE", "PLUGIN_PURGE_POLICY"}, "keep storage is only used by the %s purge policy and is ignored", cleanupCache)
+		}
+	}
+
+	// immutable tags
+	switch p.ImmutableTags {
+	case "", immutableTagsFail, immutableTagsSkip:
+	default:
+		errorf([]string{"PLUGIN_IMMUTABLE_TAGS"}, "unknown immutable tags mode %q, expected %s or %s", p.ImmutableTags, immutableTagsFail, immutableTagsSkip)
+	}
+	if p.ImmutableTags != "" && bake {
+		warnf([]string{"PLUGIN_IMMUTABLE_TAGS", "PLUGIN_BAKE_FILE"}, "immutable tags are not checked in Bake mode")
+	}
+
+	if p.VerifyPush && bake {
+		warnf([]string{"PLUGIN_VERIFY_PUSH", "PLUGIN_BAKE_FILE"}, "pushed tags are not verified in Bake mode")
+	}
+
+	// timeouts
+	if p.Timeout < 0 {
+		errorf([]string{"PLUGIN_TIMEOUT"}, "timeout must not be negative")
+	}
+	if p.GracePeriod < 0 {
+		errorf([]string{"PLUGIN_TIMEOUT_GRACE_PERIOD"}, "timeout grace period must not be negative")
+	}
+
+	return diags
+}
@@ -0,0 +1,144 @@
+package docker
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		plugin Plugin
+		want   []string // env vars of the expected diagnostics, one entry per diagnostic
+	}{
+		{
+			name:   "valid",
+			plugin: Plugin{Build: Build{Repo: "octocat/app", Tags: []string{"latest"}}},
+		},
+		{
+			name: "bake and push only",
+			plugin: Plugin{
+				Build:    Build{BakeFile: "docker-bake.hcl"},
+				PushOnly: true,
+			},
+			want: []string{"PLUGIN_BAKE_FILE,PLUGIN_PUSH_ONLY"},
+		},
+		{
+			name: "multiple errors",
+			plugin: Plugin{
+				BuildxOutputFormat: "zip",
+				TarPath:            "/tmp/image.tar",
+				Dryrun:             true,
+				Login:              Login{Password: "hunter2"},
+				Timeout:            -time.Second,
+			},
+			want: []string{
+				"PLUGIN_BUILDX_OUTPUT_FORMAT",
+				"PLUGIN_USERNAME,PLUGIN_PASSWORD",
+				"PLUGIN_TIMEOUT",
+			},
+		},
+		{
+			name: "invalid purge policy",
+			plugin: Plugin{
+				Cleanup:       true,
+				CleanupPolicy: []string{"everything"},
+			},
+			want: []string{"PLUGIN_PURGE_POLICY"},
+		},
+		{
+			name: "invalid destinations",
+			plugin: Plugin{
+				Destinations: []Destination{
+					{Tags: []string{"latest"}},
+					{Repo: "harbor.example.com/team/app", Tags: []string{"latest"}, Password: "hunter2"},
+				},
+			},
+			want: []string{"PLUGIN_DESTINATIONS", "PLUGIN_DESTINATIONS"},
+		},
+		{
+			name: "warnings are ignored",
+			plugin: Plugin{
+				Build:   Build{BakeFile: "docker-bake.hcl", NoCache: true},
+				TarPath: "/tmp/image.tar",
+			},
+		},
+		{
+			name: "warnings fail in strict mode",
+			plugin: Plugin{
+				Build:   Build{BakeFile: "docker-bake.hcl", NoCache: true},
+				TarPath: "/tmp/image.tar",
+				Strict:  true,
+			},
+			want: []string{
+				"PLUGIN_TAR_PATH,PLUGIN_BAKE_FILE",
+				"PLUGIN_CACHE_FROM,PLUGIN_CACHE_TO,PLUGIN_NO_CACHE,PLUGIN_BAKE_FILE",
+			},
+		},
+		{
+			name: "tar path without dry run",
+			plugin: Plugin{
+				TarPath: "/tmp/image.tar",
+				Strict:  true,
+			},
+			want: []string{"PLUGIN_TAR_PATH,PLUGIN_DRY_RUN"},
+		},
+		{
+			name: "tar path in push-only mode",
+			plugin: Plugin{
+				PushOnly: true,
+				TarPath:  "/tmp/image.tar",
+				Strict:   true,
+			},
+			want: []string{"PLUGIN_TAR_PATH,PLUGIN_PUSH_ONLY"},
+		},
+		{
+			name: "buildx output format without dry run",
+			plugin: Plugin{
+				TarPath:            "/tmp/image.tar",
+				BuildxOutputFormat: "oci",
+				Strict:             true,
+			},
+			want: []string{"PLUGIN_BUILDX_OUTPUT_FORMAT,PLUGIN_DRY_RUN", "PLUGIN_TAR_PATH,PLUGIN_DRY_RUN"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.plugin.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Got error %v, want *ValidationError", err)
+			}
+			var got []string
+			for _, d := range verr.Diagnostics {
+				got = append(got, strings.Join(d.EnvVars, ","))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Got diagnostics %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	err := &ValidationError{Diagnostics: []Diagnostic{
+		{Severity: SeverityError, Message: "Bake mode and Push-only mode cannot be used together", EnvVars: []string{"PLUGIN_BAKE_FILE", "PLUGIN_PUSH_ONLY"}},
+		{Severity: SeverityWarning, Message: "tar path is ignored"},
+	}}
+	want := "invalid plugin configuration, 2 problem(s) found:\n" +
+		"  - error: Bake mode and Push-only mode cannot be used together (PLUGIN_BAKE_FILE, PLUGIN_PUSH_ONLY)\n" +
+		"  - warning: tar path is ignored"
+	if err.Error() != want {
+		t.Errorf("Got %q, want %q", err.Error(), want)
+	}
+}
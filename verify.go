@@ -0,0 +1,82 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// verifyPush resolves every pushed tag from the registry and checks that all
+// tags point at the expected digest and that the manifest list contains
+// every requested platform. When digest is empty, the tags must agree on the
+// digest of the first tag. All problems are returned at once.
+func (p Plugin) verifyPush(ctx context.Context, repo string, tags []string, digest string) error {
+	client := p.registry()
+	var problems []string
+	var index manifest
+	for _, tag := range tags {
+		ref, err := parseImageRef(fmt.Sprintf("%s:%s", repo, tag))
+		if err != nil {
+			return err
+		}
+		m, found, err := client.manifest(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("unable to verify %s:%s: %w", repo, tag, err)
+		}
+		switch {
+		case !found:
+			problems = append(problems, fmt.Sprintf("%s:%s is missing", repo, tag))
+			continue
+		case digest == "":
+			digest = m.Digest
+		case m.Digest != digest:
+			problems = append(problems, fmt.Sprintf("%s:%s points at %s, expected %s", repo, tag, m.Digest, digest))
+			continue
+		}
+		if index.Digest == "" {
+			index = m
+		}
+	}
+
+	if index.Digest != "" {
+		available := index.platforms()
+		for _, platform := range buildPlatforms(p.Build.Platform) {
+			if !hasPlatform(available, platform) {
+				problems = append(problems, fmt.Sprintf("platform %s is missing from %s@%s", platform, repo, index.Digest))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("push verification failed for %s:\n  - %s", repo, strings.Join(problems, "\n  - "))
+	}
+	fmt.Printf("Verified %d tag(s) of %s at %s\n", len(tags), repo, digest)
+	return nil
+}
+
+// helper function that splits the platform setting into its platforms. A
+// single platform is not checked, since buildx pushes a plain manifest for it.
+func buildPlatforms(platform string) []string {
+	var out []string
+	for _, p := range strings.Split(platform, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	if len(out) < 2 {
+		return nil
+	}
+	return out
+}
+
+// helper function that reports whether a platform is in the manifest list.
+// A platform without a variant matches any variant, e.g. linux/arm64
+// matches linux/arm64/v8.
+func hasPlatform(available map[string]string, platform string) bool {
+	for name := range available {
+		if name == platform || strings.HasPrefix(name, platform+"/") {
+			return true
+		}
+	}
+	return false
+}
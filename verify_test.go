@@ -0,0 +1,84 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestVerifyPush(t *testing.T) {
+	srv := newTestRegistry(t, false,
+		map[string]string{"1.0": "sha256:index", "latest": "sha256:index", "stale": "sha256:old"},
+		map[string]string{"1.0": testIndex, "latest": testIndex},
+	)
+	registry := srv.Listener.Addr().String()
+	repo := registry + "/team/app"
+
+	tests := []struct {
+		name     string
+		tags     []string
+		digest   string
+		platform string
+		want     []string // expected lines of the diff, empty when verification passes
+	}{
+		{
+			name:     "verified",
+			tags:     []string{"1.0", "latest"},
+			digest:   "sha256:index",
+			platform: "linux/amd64,linux/arm64",
+		},
+		{
+			name: "tags agree without metadata",
+			tags: []string{"1.0", "latest"},
+		},
+		{
+			name:   "missing and stale tags",
+			tags:   []string{"1.0", "2.0", "stale"},
+			digest: "sha256:index",
+			want: []string{
+				repo + ":2.0 is missing",
+				repo + ":stale points at sha256:old, expected sha256:index",
+			},
+		},
+		{
+			name:     "missing platform",
+			tags:     []string{"1.0"},
+			platform: "linux/amd64, linux/arm/v7",
+			want:     []string{"platform linux/arm/v7 is missing from " + repo + "@sha256:index"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := Plugin{
+				Login:  Login{Registry: registry, Username: "octocat", Password: "secret"},
+				Daemon: Daemon{Insecure: true},
+				Build:  Build{Platform: test.platform},
+			}
+			err := p.verifyPush(context.Background(), repo, test.tags, test.digest)
+			if len(test.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected a verification error")
+			}
+			for _, line := range test.want {
+				if !strings.Contains(err.Error(), "  - "+line) {
+					t.Errorf("error %q does not contain %q", err, line)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildPlatforms(t *testing.T) {
+	if got := buildPlatforms("linux/amd64"); got != nil {
+		t.Errorf("single platform should not be checked, got %q", got)
+	}
+	if got := buildPlatforms("linux/amd64, linux/arm64,"); len(got) != 2 || got[1] != "linux/arm64" {
+		t.Errorf("got platforms %q", got)
+	}
+}